@@ -0,0 +1,113 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"strings"
+	"vote/storage"
+	"vote/tgclient"
+)
+
+// ensureUser registers the user on the first action that needs it, /start is not required
+func (b *Bot) ensureUser(u tgclient.User) {
+	if u.Id == 0 {
+		return
+	}
+	added, err := b.storage.Register(u.Id, profile(u))
+	if err != nil {
+		slog.Error("Failed to register user: " + err.Error())
+	}
+	if added {
+		slog.Info(fmt.Sprintf("user %d registered implicitly", u.Id))
+	}
+}
+
+func (b *Bot) leave(ctx context.Context, msg *tgclient.Message) {
+	loc := b.locale(msg.From)
+	text := loc.text("leave.done")
+	if err := b.storage.Leave(msg.From.Id); err != nil {
+		if errors.Is(err, storage.ErrUnknownUser) {
+			text = loc.text("leave.not_member")
+		} else {
+			slog.Error("failed to handle leave: " + err.Error())
+			text = loc.text("common.failed")
+		}
+	}
+	if err := b.client.Answer(ctx, msg, text); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+func (b *Bot) forgetMe(ctx context.Context, msg *tgclient.Message) {
+	// only the user can confirm, so the keyboard carries their id
+	loc := b.locale(msg.From)
+	session := b.storage.Session()
+	keyboard := tgclient.InlineKeyboardMarkup{Keyboard: [][]tgclient.InlineKeyboardButton{{
+		{Text: loc.text("forget.button"), Data: b.codec.Encode(callbackData{Action: actForget, Session: session, Arg: msg.From.Id})},
+		{Text: loc.text("common.cancel"), Data: b.codec.Encode(callbackData{Action: actForgetCancel, Session: session, Arg: msg.From.Id})},
+	}}}
+	if _, err := b.client.AnswerWithResult(ctx, msg, loc.text("forget.confirm"), &keyboard); err != nil {
+		slog.Error("failed to send forget confirmation: " + err.Error())
+	}
+}
+
+func (b *Bot) processForget(ctx context.Context, update *tgclient.Update, data callbackData) {
+	loc := b.locale(update.Callback.From)
+	if update.Callback.From.Id != data.Arg {
+		b.answerCallback(ctx, update, loc.text("forget.not_yours"), false)
+		return
+	}
+
+	text := loc.text("forget.nothing")
+	if data.Action == actForget {
+		forgotten, err := b.storage.Forget(data.Arg)
+		switch {
+		case err == nil:
+			text = forgottenText(loc, forgotten)
+			b.refreshMonitors()
+		case errors.Is(err, storage.ErrUnknownUser):
+			text = loc.text("forget.gone")
+		default:
+			slog.Error("failed to forget user: " + err.Error())
+			text = loc.text("forget.failed")
+		}
+	}
+
+	if err := b.client.EditMessage(
+		ctx,
+		update.Callback.Message.Chat.Id,
+		update.Callback.Message.Id,
+		text,
+		tgclient.InlineKeyboardMarkup{Keyboard: [][]tgclient.InlineKeyboardButton{}},
+	); err != nil {
+		slog.Error("failed to edit forget confirmation: " + err.Error())
+	}
+	b.answerCallback(ctx, update, "", false)
+}
+
+func forgottenText(loc locale, f storage.Forgotten) string {
+	deleted := []string{loc.text("forget.profile")}
+	if f.Vote {
+		deleted = append(deleted, loc.text("forget.vote"))
+	}
+	if f.RSVPs > 0 {
+		deleted = append(deleted, loc.format("forget.rsvps", msgData{Count: f.RSVPs}))
+	}
+
+	builder := strings.Builder{}
+	builder.WriteString(loc.format("forget.deleted", msgData{HTML: template.HTML(strings.Join(deleted, ", "))}) + "\n")
+	if f.Films > 0 {
+		builder.WriteString(loc.format("forget.films", msgData{Count: f.Films}) + "\n")
+	}
+	if f.Sessions > 0 {
+		builder.WriteString(loc.format("forget.sessions", msgData{Count: f.Sessions}) + "\n")
+	}
+	if f.Events > 0 {
+		builder.WriteString(loc.format("forget.events", msgData{Count: f.Events}) + "\n")
+	}
+	builder.WriteString(loc.text("forget.bye"))
+	return builder.String()
+}
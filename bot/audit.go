@@ -0,0 +1,163 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+	"vote/storage"
+	"vote/tgclient"
+)
+
+const auditLimit = 20
+
+// admin command
+func (b *Bot) audit(ctx context.Context, msg *tgclient.Message, arg string) {
+	if !b.isAdmin(msg.From.Id) {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("common.not_admin")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	loc := b.locale(msg.From)
+	if arg == "" {
+		if err := b.client.Answer(ctx, msg, loc.text("audit.usage")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	events, err := b.storage.Events(nil, 0)
+	if err != nil {
+		slog.Error("failed to read events: " + err.Error())
+		if err := b.client.Answer(ctx, msg, loc.text("audit.read_failed")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	users := b.storage.Snapshot().Users
+	films := map[int]string{}
+	for _, e := range events {
+		switch e.Type {
+		case storage.EventAdd, storage.EventRename:
+			films[e.Film] = e.Name
+		case storage.EventRegister, storage.EventProfile:
+			if _, ok := users[e.User]; !ok {
+				users[e.User] = storage.UserInfo{Name: e.Name, Username: e.Username}
+			}
+		}
+	}
+
+	match := auditFilter(arg, users, events)
+	var res []storage.Event
+	for _, e := range events {
+		if match(e) {
+			res = append(res, e)
+		}
+	}
+	if len(res) > auditLimit {
+		res = res[len(res)-auditLimit:]
+	}
+
+	text := loc.text("audit.empty")
+	if len(res) > 0 {
+		builder := strings.Builder{}
+		for _, e := range res {
+			builder.WriteString(formatEvent(loc, e, users, films))
+			builder.WriteString("\n")
+		}
+		text = builder.String()
+	}
+	if err := b.client.Answer(ctx, msg, text); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+// auditFilter matches events of a user (@username or id) or of a film (name, including former names)
+func auditFilter(arg string, users map[int64]storage.UserInfo, events []storage.Event) func(storage.Event) bool {
+	userID, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil && strings.HasPrefix(arg, "@") {
+		for id, info := range users {
+			if strings.EqualFold(info.Username, arg[1:]) {
+				userID, err = id, nil
+				break
+			}
+		}
+	}
+	if err == nil {
+		return func(e storage.Event) bool {
+			return e.User == userID
+		}
+	}
+
+	ids := map[int]struct{}{}
+	for _, e := range events {
+		switch e.Type {
+		case storage.EventAdd, storage.EventRename, storage.EventRemove:
+			if e.Name == arg {
+				ids[e.Film] = struct{}{}
+			}
+		}
+	}
+	return func(e storage.Event) bool {
+		switch e.Type {
+		case storage.EventVote, storage.EventRetract:
+			_, before := ids[e.Before]
+			_, after := ids[e.After]
+			return before || after
+		case storage.EventAdd, storage.EventRename, storage.EventRemove, storage.EventDisown:
+			_, ok := ids[e.Film]
+			return ok
+		}
+		return false
+	}
+}
+
+func formatEvent(loc locale, e storage.Event, users map[int64]storage.UserInfo, films map[int]string) string {
+	who := storage.UserInfo{Name: "?"}
+	if e.User == 0 {
+		who.Name = loc.text("audit.system")
+	} else if u, ok := users[e.User]; ok {
+		who = u
+	}
+	film := func(id int) string {
+		if id == 0 {
+			return "—"
+		}
+		if name, ok := films[id]; ok {
+			return name
+		}
+		return fmt.Sprintf("#%d", id)
+	}
+
+	var what string
+	switch e.Type {
+	case storage.EventRegister:
+		what = loc.text("audit.register")
+	case storage.EventAdd:
+		what = loc.format("audit.add", msgData{Film: storage.FilmStat{Name: film(e.Film)}})
+	case storage.EventRemove:
+		what = loc.format("audit.remove", msgData{Film: storage.FilmStat{Name: e.Name}})
+	case storage.EventRename:
+		what = loc.format("audit.rename", msgData{Film: storage.FilmStat{Name: e.Name}})
+	case storage.EventVote, storage.EventRetract:
+		what = loc.format("audit.vote", msgData{Text: film(e.Before), Film: storage.FilmStat{Name: film(e.After)}})
+	case storage.EventReset:
+		what = loc.text("audit.reset")
+	case storage.EventProfile:
+		what = loc.format("audit.profile", msgData{User: storage.UserInfo{Name: e.Name, Username: e.Username}})
+	case storage.EventRunoff:
+		what = loc.text("audit.runoff")
+	case storage.EventDisown:
+		what = loc.format("audit.disown", msgData{Film: storage.FilmStat{Name: film(e.Film)}})
+	default:
+		what = escape(string(e.Type))
+	}
+
+	return loc.format("audit.line", msgData{Time: time.Unix(e.Time, 0), User: who, HTML: template.HTML(what)})
+}
@@ -1,8 +1,12 @@
 package bot
 
 import (
+	"context"
 	"fmt"
+	"io/fs"
 	"log/slog"
+	"os"
+	"slices"
 	"sync"
 	"time"
 	"vote/config"
@@ -13,6 +17,11 @@ import (
 type Bot struct {
 	client  *tgclient.Client
 	storage *storage.Storage
+	codec   *callbackCodec
+	// texts by language
+	messages *catalog
+
+	scheduler *scheduler
 
 	fetchInterval time.Duration
 	limit         int
@@ -20,14 +29,36 @@ type Bot struct {
 
 	admins     []int64
 	mainChatId int64
-	monitors   []int64
-	monitorCh  chan struct{}
-	startTime  time.Time
-	stopCh     chan struct{}
-	doneCh     chan struct{}
+	posterMode string
+	runoffTime time.Duration
+	// how long before a screening to remind
+	screeningRemind time.Duration
+	monitorCh       chan struct{}
+	startTime       time.Time
+	stopCh          chan struct{}
+	doneCh          chan struct{}
+
+	// actions waiting for confirmation
+	imports    *pending[[]string]
+	broadcasts *pending[broadcast]
+
+	// last membership checks in the main chat
+	membersMu     sync.Mutex
+	memberChecked map[int64]time.Time
+}
+
+// Option changes defaults of the bot
+type Option func(*Bot)
+
+// Clock tells the time to scheduled jobs and to deadline checks
+type Clock = storage.Clock
+
+// WithClock replaces the system clock, e.g. in tests
+func WithClock(c Clock) Option {
+	return func(b *Bot) { b.storage.SetClock(c) }
 }
 
-func New(cfg *config.Config, token string) (*Bot, error) {
+func New(cfg *config.Config, token string, opts ...Option) (*Bot, error) {
 	if token == "" {
 		return nil, fmt.Errorf("no token provided")
 	}
@@ -35,27 +66,93 @@ func New(cfg *config.Config, token string) (*Bot, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create storage: %w", err)
 	}
-	return &Bot{
-		client:        tgclient.NewClient(token),
-		storage:       st,
-		admins:        cfg.Admins,
-		mainChatId:    cfg.MainChatId,
-		fetchInterval: cfg.FetchInterval,
-		limit:         cfg.Limit,
-		offset:        cfg.Offset,
-		monitorCh:     make(chan struct{}),
-		stopCh:        make(chan struct{}),
-		doneCh:        make(chan struct{}),
-	}, nil
+	tieBreak, err := storage.ParseTieBreak(cfg.TieBreak)
+	if err != nil {
+		return nil, err
+	}
+	st.SetTieBreak(tieBreak)
+	rules := storage.Rules{
+		MembersOnly: cfg.Eligibility.MembersOnly,
+		Attended:    cfg.Eligibility.Attended,
+		Of:          cfg.Eligibility.Of,
+		LoserBonus:  cfg.Weights.LoserBonus,
+		LoserStreak: cfg.Weights.LoserStreak,
+	}
+	if err := rules.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid eligibility or weights: %w", err)
+	}
+	st.SetRules(rules)
+	lang := cfg.Language
+	if lang == "" {
+		lang = defaultLang
+	}
+	locales, err := fs.Sub(localesFS, "locales")
+	if err != nil {
+		return nil, err
+	}
+	sources := []fs.FS{locales}
+	if cfg.Templates != "" {
+		if _, err := os.Stat(cfg.Templates); err != nil {
+			return nil, fmt.Errorf("invalid templates directory: %w", err)
+		}
+		sources = append(sources, os.DirFS(cfg.Templates))
+	}
+	messages, err := loadCatalog(lang, sources...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load messages: %w", err)
+	}
+	runoffTime := cfg.RunoffDuration
+	if runoffTime == 0 {
+		runoffTime = defaultRunoffTime
+	}
+	screeningRemind := cfg.ScreeningReminder
+	if screeningRemind == 0 {
+		screeningRemind = defaultScreeningRemind
+	}
+	b := &Bot{
+		client:          tgclient.NewClient(token),
+		storage:         st,
+		codec:           newCallbackCodec(token),
+		messages:        messages,
+		admins:          cfg.Admins,
+		mainChatId:      cfg.MainChatId,
+		posterMode:      cfg.PosterMode,
+		runoffTime:      runoffTime,
+		screeningRemind: screeningRemind,
+		fetchInterval:   cfg.FetchInterval,
+		limit:           cfg.Limit,
+		offset:          cfg.Offset,
+		monitorCh:       make(chan struct{}, 1),
+		stopCh:          make(chan struct{}),
+		doneCh:          make(chan struct{}),
+		imports:         newPending[[]string](),
+		broadcasts:      newPending[broadcast](),
+		memberChecked:   map[int64]time.Time{},
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.scheduler = newScheduler(st)
+
+	b.scheduler.add(job{name: jobRunoff, due: b.scheduler.afterDeadlineDue(), run: b.checkRunoff})
+	b.scheduler.add(job{run: b.remindScreenings})
+	b.scheduler.add(job{run: b.checkVoters})
+	if err := b.addJobs(cfg.Jobs); err != nil {
+		return nil, err
+	}
+
+	return b, nil
 }
 
-func (b *Bot) Start() {
+// Start launches update processing. Cancelling ctx aborts in-flight requests and stops the bot.
+func (b *Bot) Start(ctx context.Context) {
 	slog.Info("Bot is starting...")
 
-	b.setCommands()
+	b.setCommands(ctx)
 
 	b.startTime = time.Now()
-	go b.startFetching()
+	go b.startFetching(ctx)
 
 	slog.Info("Bot is running")
 }
@@ -80,7 +177,7 @@ func (b *Bot) Wait() <-chan struct{} {
 	return waitCh
 }
 
-func (b *Bot) startFetching() {
+func (b *Bot) startFetching(ctx context.Context) {
 	fetchTicker := time.NewTicker(b.fetchInterval)
 	defer fetchTicker.Stop()
 
@@ -88,16 +185,21 @@ func (b *Bot) startFetching() {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		slog.Debug(fmt.Sprintf("monitor: %v", b.storage.GetMonitor()))
-		b.updateMonitors()
+		slog.Debug(fmt.Sprintf("monitors: %v", b.storage.Monitors()))
+		b.updateMonitors(ctx)
 		slog.Debug("Monitor stopped")
 	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		b.scheduler.run(ctx, b.stopCh)
+	}()
 
 	for {
 		select {
 
 		case <-fetchTicker.C:
-			updates, err := b.client.Updates(b.limit, b.offset)
+			updates, err := b.client.Updates(ctx, b.limit, b.offset)
 			if err != nil {
 				slog.Error(fmt.Sprintf("error getting updates: %s", err.Error()))
 				continue
@@ -112,12 +214,15 @@ func (b *Bot) startFetching() {
 				wg.Add(1)
 				go func() {
 					defer wg.Done()
-					b.process(updates[i])
+					b.process(ctx, updates[i])
 				}()
 			}
 
 			b.offset = updates[len(updates)-1].Id + 1
 
+		case <-ctx.Done():
+			b.Stop()
+
 		case <-b.stopCh:
 			slog.Info("Waiting for processing to finish")
 			wg.Wait()
@@ -128,7 +233,7 @@ func (b *Bot) startFetching() {
 	}
 }
 
-func (b *Bot) process(update tgclient.Update) {
+func (b *Bot) process(ctx context.Context, update tgclient.Update) {
 	slog.Info(
 		"Processing update",
 		"from", fmt.Sprintf("%s (@%s)", update.Message.From.Name, update.Message.From.Username),
@@ -136,83 +241,108 @@ func (b *Bot) process(update tgclient.Update) {
 		"date", time.Unix(update.Message.Date, 0).Format("2006-01-02 15:04:05"),
 	)
 
+	from := update.Message.From
 	if update.Callback.Data != "" {
-		b.processCallback(&update)
+		from = update.Callback.From
+	}
+	b.refreshProfile(from)
+
+	if update.Callback.Data != "" {
+		b.processCallback(ctx, &update)
 	} else {
-		b.processCommand(&update)
+		b.processCommand(ctx, &update)
+	}
+}
+
+// refreshMonitors schedules a monitors update without blocking the caller.
+// Several calls in a row are coalesced into a single update.
+func (b *Bot) refreshMonitors() {
+	select {
+	case b.monitorCh <- struct{}{}:
+	default:
 	}
 }
 
-func (b *Bot) updateMonitors() {
+func (b *Bot) updateMonitors(ctx context.Context) {
 	slog.Debug("Monitoring...")
 	defer slog.Debug("Monitoring stopped")
 
+	// the timer is armed by the first refresh and not pushed back by later ones,
+	// so votes arriving one after another can't postpone the update forever
+	debounce := time.NewTimer(monitorDebounce)
+	debounce.Stop()
+	defer debounce.Stop()
+	armed := false
+
 	for {
 		select {
 		case <-b.monitorCh:
-			slog.Debug("Updating monitor!")
-			mon := b.storage.GetMonitor()
-			text := statusText(b.storage.Status(), 0)
-			if err := b.client.EditMessage(
-				mon.ChatId, mon.MsgId,
-				text,
-				tgclient.InlineKeyboardMarkup{Keyboard: [][]tgclient.InlineKeyboardButton{}},
-			); err != nil {
-				slog.Error("Failed to update monitor: " + err.Error())
+			if !armed {
+				debounce.Reset(monitorDebounce)
+				armed = true
+			}
+
+		case <-debounce.C:
+			armed = false
+			slog.Debug("Updating monitors!")
+			stats := b.storage.Status()
+			text := b.dashboardText(stats, time.Now())
+			keyboard := b.voteKeyboard(stats)
+			for _, mon := range b.storage.Monitors() {
+				err := b.client.EditMessage(ctx, mon.ChatId, mon.MsgId, text, keyboard)
+				switch {
+				case err == nil || tgclient.IsNotModified(err):
+				case tgclient.IsMessageNotFound(err):
+					slog.Info(fmt.Sprintf("monitor chat=%d msg=%d is gone, removing", mon.ChatId, mon.MsgId))
+					b.storage.RemoveMonitor(mon.ChatId, mon.MsgId)
+				default:
+					slog.Error("Failed to update monitor: " + err.Error())
+				}
 			}
 
 		case <-b.stopCh:
 			slog.Debug("updateMonitors stopped")
 			return
+		case <-ctx.Done():
+			return
 		}
 	}
 }
 
-func (b *Bot) setCommands() {
-	if err := b.loadAdmins(); err != nil {
+func (b *Bot) setCommands(ctx context.Context) {
+	if err := b.loadAdmins(ctx); err != nil {
 		slog.Error("Faield to load chat admins: " + err.Error())
 	}
 
-	if err := b.client.SetCommandsPrivate([][]string{
-		{cmdStatus, "Посмотреть список фильмов"},
-		{cmdVote, "Голосовать за фильм"},
-		{cmdAdd, "Добавть фильм в список"},
-		{cmdStatusFull, "Список фильмов с голосами"},
-		{cmdHelp, "Помощь"},
-	}); err != nil {
-		slog.Error("Failed to set private commands: " + err.Error())
-	}
-	// for _, id := range b.admins {
-	// 	if err := b.client.SetCommandChat([][]string{
-	// 		{cmdStatus, "Посмотреть список фильмов"},
-	// 		{cmdVote, "Голосовать за фильм"},
-	// 		{cmdAdd, "Добавть фильм в список"},
-	// 		{cmdStatusFull, "Список фильмов с голосами"},
-	// 		{cmdHelp, "Помощь"},
-	// 		{cmdRemove, "😈 Удалить фильм из списка"},
-	// 		{cmdReset, "😈 Сбросить ВСЕ голоса"},
-	// 		{cmdMonitor, "😈 Сообщение /status с автообновлением"},
-	// 	}, id); err != nil {
-	// 		slog.Error("failed to set private commands for admons: " + err.Error())
-	// 	}
-	// }
-	if err := b.client.SetCommandsGroup([][]string{
-		{cmdStatus, "Посмотреть список фильмов"},
-		{cmdAdd, "Добавть фильм в список"},
-		{cmdStatusFull, "Список фильмов с голосами"},
-		{cmdHelp, "Помощь"},
-	}); err != nil {
-		slog.Error("failed to set group commands: " + err.Error())
-	}
-	if err := b.client.SetCommandsGroupAdmin([][]string{
-		{cmdStatus, "Посмотреть список фильмов"},
-		{cmdAdd, "Добавть фильм в список"},
-		{cmdStatusFull, "Список фильмов с голосами"},
-		{cmdHelp, "Помощь"},
-		{cmdRemove, "😈 Удалить фильм из списка"},
-		{cmdReset, "😈 Сбросить ВСЕ голоса"},
-		{cmdMonitor, "😈 Сообщение /status с автообновлением"},
-	}); err != nil {
-		slog.Error("failed to set group admin commands: " + err.Error())
+	private := []string{cmdStatus, cmdVote, cmdAdd, cmdStatusFull, cmdCard, cmdStats, cmdLeave, cmdForgetMe, cmdLang, cmdHelp}
+	group := []string{cmdStatus, cmdAdd, cmdStatusFull, cmdCard, cmdStats, cmdHelp}
+	admin := append(slices.Clone(group),
+		cmdRemove, cmdReset, cmdMonitor, cmdDeadline, cmdPoster, cmdTrailer, cmdExport,
+		cmdImport, cmdAudit, cmdScreening, cmdAttended, cmdBroadcast,
+	)
+
+	// the default language also serves clients without a translation
+	for _, lang := range append(b.messages.langs(), "") {
+		loc := b.chatLocale()
+		if lang != "" {
+			loc.lang = lang
+		}
+		if err := b.client.SetCommandsPrivate(ctx, commandList(loc, private), lang); err != nil {
+			slog.Error("Failed to set private commands: " + err.Error())
+		}
+		if err := b.client.SetCommandsGroup(ctx, commandList(loc, group), lang); err != nil {
+			slog.Error("failed to set group commands: " + err.Error())
+		}
+		if err := b.client.SetCommandsGroupAdmin(ctx, commandList(loc, admin), lang); err != nil {
+			slog.Error("failed to set group admin commands: " + err.Error())
+		}
+	}
+}
+
+func commandList(loc locale, cmds []string) [][]string {
+	res := make([][]string, len(cmds))
+	for i, cmd := range cmds {
+		res[i] = []string{cmd, loc.text("commands." + cmd)}
 	}
+	return res
 }
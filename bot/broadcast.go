@@ -0,0 +1,181 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"slices"
+	"time"
+	"vote/tgclient"
+)
+
+const (
+	// DMs are sent well below the global rate limit, so other replies aren't delayed
+	broadcastInterval = time.Second / 10
+	// how often the progress report is updated
+	broadcastProgress = time.Second * 3
+)
+
+// broadcast is either a text or a copy of an existing message
+type broadcast struct {
+	Text     string
+	FromChat int64
+	MsgId    int64
+	// users shown in the preview, the admin confirms sending to them
+	Recipients []int64
+}
+
+// broadcastReport is exported to templates as .Report
+type broadcastReport struct {
+	Total, Delivered, Blocked, Failed int
+}
+
+// Sent counts recipients handled so far
+func (r broadcastReport) Sent() int {
+	return r.Delivered + r.Blocked + r.Failed
+}
+
+func (r broadcastReport) text(loc locale) string {
+	return loc.format("broadcast.report", msgData{Report: r})
+}
+
+// admin command
+func (b *Bot) broadcast(ctx context.Context, msg *tgclient.Message, arg string) {
+	if !b.isAdmin(msg.From.Id) {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("common.not_admin")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	loc := b.locale(msg.From)
+	var bc broadcast
+	switch {
+	case arg != "":
+		// the text is sent as is, formatting can be kept by replying to a message instead
+		bc.Text = escape(arg)
+	case msg.ReplyTo != nil:
+		bc.FromChat, bc.MsgId = msg.ReplyTo.Chat.Id, msg.ReplyTo.Id
+	default:
+		if err := b.client.Answer(ctx, msg, loc.text("broadcast.usage")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	recipients, skipped := b.broadcastRecipients()
+	bc.Recipients = recipients
+	id := b.broadcasts.add(bc)
+	session := b.storage.Session()
+	keyboard := tgclient.InlineKeyboardMarkup{Keyboard: [][]tgclient.InlineKeyboardButton{{
+		{Text: loc.text("broadcast.send"), Data: b.codec.Encode(callbackData{Action: actBroadcast, Session: session, Arg: id})},
+		{Text: loc.text("common.cancel"), Data: b.codec.Encode(callbackData{Action: actBroadcastCancel, Session: session, Arg: id})},
+	}}}
+	text := broadcastPreview(loc, bc, len(recipients), skipped)
+	if _, err := b.client.AnswerWithResult(ctx, msg, text, &keyboard); err != nil {
+		slog.Error("failed to send broadcast preview: " + err.Error())
+	}
+}
+
+// broadcastPreview shows what will be sent and to how many users
+func broadcastPreview(loc locale, bc broadcast, recipients int, skipped int) string {
+	preview := template.HTML(bc.Text)
+	if bc.MsgId != 0 {
+		preview = template.HTML(loc.text("broadcast.reply"))
+	}
+	return loc.format("broadcast.preview", msgData{Count: recipients, Total: skipped, HTML: preview})
+}
+
+// broadcastRecipients returns club members except those who blocked the bot,
+// the number of them is returned as skipped
+func (b *Bot) broadcastRecipients() ([]int64, int) {
+	var res []int64
+	skipped := 0
+	for id, info := range b.storage.Snapshot().Users {
+		if info.Quit {
+			continue
+		}
+		if info.Inactive {
+			skipped++
+			continue
+		}
+		res = append(res, id)
+	}
+	return res, skipped
+}
+
+// stillRegistered drops users who left the club or were forgotten since the preview
+func (b *Bot) stillRegistered(ids []int64) []int64 {
+	users := b.storage.Snapshot().Users
+	return slices.DeleteFunc(slices.Clone(ids), func(id int64) bool {
+		info, ok := users[id]
+		return !ok || info.Quit
+	})
+}
+
+func (b *Bot) processBroadcast(ctx context.Context, update *tgclient.Update, data callbackData) {
+	if !b.isAdmin(update.Callback.From.Id) {
+		b.answerCallback(ctx, update, b.locale(update.Callback.From).text("common.not_admin"), false)
+		return
+	}
+
+	loc := b.locale(update.Callback.From)
+	bc, ok := b.broadcasts.take(data.Arg)
+	if !ok {
+		b.answerCallback(ctx, update, loc.text("broadcast.handled"), false)
+		return
+	}
+
+	chatID, msgID := update.Callback.Message.Chat.Id, update.Callback.Message.Id
+	emptyKeyboard := tgclient.InlineKeyboardMarkup{Keyboard: [][]tgclient.InlineKeyboardButton{}}
+	report := func(text string) {
+		if err := b.client.EditMessage(ctx, chatID, msgID, text, emptyKeyboard); err != nil && !tgclient.IsNotModified(err) {
+			slog.Error("failed to update broadcast report: " + err.Error())
+		}
+	}
+
+	if data.Action == actBroadcastCancel {
+		b.answerCallback(ctx, update, loc.text("broadcast.cancelled"), false)
+		report(loc.text("broadcast.cancelled"))
+		return
+	}
+	b.answerCallback(ctx, update, loc.text("broadcast.sending"), false)
+
+	recipients := b.stillRegistered(bc.Recipients)
+	r := broadcastReport{Total: len(recipients)}
+	report(r.text(loc))
+
+	lastReport := time.Now()
+	for i, id := range recipients {
+		if i > 0 {
+			if err := tgclient.SleepCtx(ctx, broadcastInterval); err != nil {
+				break
+			}
+		}
+
+		var err error
+		if bc.MsgId != 0 {
+			err = b.client.CopyMessage(ctx, id, bc.FromChat, bc.MsgId)
+		} else {
+			err = b.client.SendMessage(ctx, id, bc.Text)
+		}
+		switch {
+		case err == nil:
+			r.Delivered++
+		case tgclient.IsBlocked(err):
+			r.Blocked++
+			b.markInactive(id)
+		default:
+			r.Failed++
+			slog.Error(fmt.Sprintf("failed to broadcast to user %d: %s", id, err.Error()))
+		}
+
+		if time.Since(lastReport) >= broadcastProgress {
+			report(r.text(loc))
+			lastReport = time.Now()
+		}
+	}
+
+	report(r.text(loc) + "\n\n" + loc.text("broadcast.done"))
+}
@@ -1,57 +1,134 @@
 package bot
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
 	"log/slog"
 	"math/rand"
-	"strconv"
-	"strings"
+	"vote/storage"
 	"vote/tgclient"
 )
 
-const (
-	prefSize = 4
-	prefVote = "film"
-)
+func (b *Bot) processCallback(ctx context.Context, update *tgclient.Update) {
+	data, err := b.codec.Decode(update.Callback.Data)
+	_, rsvp := rsvpActions[data.Action]
+	if err == nil && !rsvp && data.Session != b.storage.Session() {
+		err = errOutdatedCallback
+	}
+	if err != nil {
+		slog.Warn("Rejected callback: "+err.Error(), "data", update.Callback.Data)
+		b.answerCallback(ctx, update, b.locale(update.Callback.From).text("vote.outdated"), true)
+		return
+	}
 
-func (b *Bot) processCallback(update *tgclient.Update) {
-	emptyKeyboard := tgclient.InlineKeyboardMarkup{Keyboard: [][]tgclient.InlineKeyboardButton{}}
+	switch data.Action {
+	case actVote, actRetract:
+		b.processVote(ctx, update, data)
+	case actImport, actImportCancel:
+		b.processImport(ctx, update, data)
+	case actForget, actForgetCancel:
+		b.processForget(ctx, update, data)
+	case actBroadcast, actBroadcastCancel:
+		b.processBroadcast(ctx, update, data)
+	case actGoing, actMaybe, actNotGoing:
+		b.processRSVP(ctx, update, data)
+	default:
+		slog.Warn(fmt.Sprintf("Unknown callback action: %q", data.Action))
+		b.answerCallback(ctx, update, "", false)
+	}
+}
 
-	if strings.HasPrefix(update.Callback.Data, prefVote) {
-		id, err := strconv.ParseInt(update.Callback.Data[prefSize:], 10, 64)
-		if err != nil {
-			slog.Error("Failed to parse callback data: " + err.Error())
-		}
+func (b *Bot) processVote(ctx context.Context, update *tgclient.Update, data callbackData) {
+	var id int64
+	if data.Action == actVote {
+		id = data.Arg
+	}
 
-		ok, err := b.storage.Vote(update.Callback.From.Id, int(id))
-		if err != nil {
-			slog.Error("Failed to process callback: " + err.Error())
-		}
+	b.ensureUser(update.Callback.From)
+	b.checkMember(ctx, update.Callback.From.Id)
+	ok, err := b.storage.Vote(update.Callback.From.Id, int(id))
+	if err != nil {
+		slog.Error("Failed to process callback: " + err.Error())
+	}
+	var ineligible error
+	if ok && id != 0 {
+		_, ineligible = b.storage.Ballot(update.Callback.From.Id)
+	}
 
-		if ok {
-			err = b.client.EditMessage(
-				update.Callback.From.Id,
-				update.Callback.Message.Id,
-				"Отличный выбор "+randEmoji(),
-				emptyKeyboard,
-			)
-		} else {
-			err = b.client.EditMessage(
-				update.Callback.From.Id,
-				update.Message.Id,
-				"Что-то пошло не так",
-				emptyKeyboard,
-			)
-		}
-		if err != nil {
-			slog.Error("Failed to send message after vote: " + err.Error())
+	loc := b.locale(update.Callback.From)
+	var toast string
+	alert := false
+	switch {
+	case ok && id == 0:
+		toast = loc.text("vote.retracted")
+	case ok && ineligible != nil:
+		toast = loc.format("vote.ineligible", msgData{HTML: template.HTML(ineligibleText(loc, ineligible))})
+		alert = true
+	case ok:
+		toast = loc.format("vote.counted", msgData{Emoji: randEmoji(loc)})
+	case errors.Is(err, storage.ErrUnknownFilm):
+		toast = loc.text("vote.film_removed")
+		alert = true
+	case errors.Is(err, storage.ErrVotingClosed):
+		toast = loc.text("vote.closed")
+		alert = true
+	case errors.Is(err, storage.ErrNotInRunoff):
+		toast = loc.text("vote.not_in_runoff")
+		alert = true
+	case errors.Is(err, storage.ErrQuit):
+		toast = loc.text("vote.quit")
+		alert = true
+	case errors.Is(err, storage.ErrUnknownUser):
+		toast = loc.text("common.start_first")
+		alert = true
+	default:
+		toast = loc.text("common.failed")
+	}
+
+	if update.Callback.Message.Chat.Type == tgclient.ChatTypePrivate {
+		text := toast
+		if !ok && !errors.Is(err, storage.ErrVotingClosed) && !errors.Is(err, storage.ErrQuit) {
+			text += "\n" + loc.text("vote.again")
+		} else if id != 0 && ineligible == nil {
+			text = loc.format("vote.great", msgData{Emoji: randEmoji(loc)})
 		}
-		b.monitorCh <- struct{}{}
+		b.voteAnswerPrivate(ctx, update, text)
+	}
+	// the shared dashboard is updated by the monitor, a toast is enough
+	b.answerCallback(ctx, update, toast, alert)
+
+	if ok {
+		b.refreshMonitors()
+	}
+}
+
+// voteAnswerPrivate edits the personal voting message
+func (b *Bot) voteAnswerPrivate(ctx context.Context, update *tgclient.Update, text string) {
+	emptyKeyboard := tgclient.InlineKeyboardMarkup{Keyboard: [][]tgclient.InlineKeyboardButton{}}
+
+	if err := b.client.EditMessage(ctx,
+		update.Callback.From.Id,
+		update.Callback.Message.Id,
+		text,
+		emptyKeyboard,
+	); err != nil && !tgclient.IsNotModified(err) {
+		slog.Error("Failed to send message after vote: " + err.Error())
 	}
 }
 
-var emojis = []rune("🫡🤯💩🤡👍👎😡🤓🌚🔥")
+func (b *Bot) answerCallback(ctx context.Context, update *tgclient.Update, text string, alert bool) {
+	if err := b.client.AnswerCallbackQuery(ctx, update.Callback.Id, text, alert, 0); err != nil {
+		slog.Error("Failed to answer callback: " + err.Error())
+	}
+}
 
-func randEmoji() string {
-	i := rand.Intn(len(emojis))
-	return string(emojis[i])
+// randEmoji picks one of vote.emojis
+func randEmoji(loc locale) string {
+	emojis := []rune(loc.text("vote.emojis"))
+	if len(emojis) == 0 {
+		return ""
+	}
+	return string(emojis[rand.Intn(len(emojis))])
 }
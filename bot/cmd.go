@@ -1,10 +1,14 @@
 package bot
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"html/template"
 	"log/slog"
 	"strings"
 	"time"
+	"vote/storage"
 	"vote/tgclient"
 )
 
@@ -13,18 +17,32 @@ const (
 	cmdStart = "start"
 
 	cmdAdd        = "add"
+	cmdCard       = "card"
+	cmdForgetMe   = "forget_me"
+	cmdLang       = "lang"
+	cmdLeave      = "leave"
 	cmdStatus     = "status"
+	cmdStats      = "stats"
 	cmdStatusFull = "status_full"
 	cmdVote       = "vote"
 
 	// admin commands
-	cmdMonitor = "monitor"
-	cmdReboot  = "reboot"
-	cmdRemove  = "remove"
-	cmdReset   = "reset"
+	cmdAttended  = "attended"
+	cmdAudit     = "audit"
+	cmdBroadcast = "broadcast"
+	cmdDeadline  = "deadline"
+	cmdExport    = "export"
+	cmdImport    = "import"
+	cmdMonitor   = "monitor"
+	cmdPoster    = "poster"
+	cmdReboot    = "reboot"
+	cmdRemove    = "remove"
+	cmdReset     = "reset"
+	cmdScreening = "screening"
+	cmdTrailer   = "trailer"
 )
 
-func (b *Bot) processCommand(update *tgclient.Update) {
+func (b *Bot) processCommand(ctx context.Context, update *tgclient.Update) {
 	var ent tgclient.Enitiy
 	for _, e := range update.Message.Entities {
 		if e.Type == tgclient.EntityBotCommand {
@@ -48,226 +66,338 @@ func (b *Bot) processCommand(update *tgclient.Update) {
 	switch cmd {
 
 	case cmdHelp:
-		b.help(&update.Message)
+		b.help(ctx, &update.Message)
 	case cmdStart:
-		b.register(&update.Message)
+		b.register(ctx, &update.Message)
+	case cmdLeave:
+		b.leave(ctx, &update.Message)
+	case cmdForgetMe:
+		b.forgetMe(ctx, &update.Message)
+	case cmdLang:
+		b.lang(ctx, &update.Message, strings.TrimSpace(update.Message.Text[sep:]))
 
 	case cmdAdd:
-		b.addFilm(&update.Message, strings.TrimSpace(update.Message.Text[sep:]))
-		b.monitorCh <- struct{}{}
+		b.addFilm(ctx, &update.Message, strings.TrimSpace(update.Message.Text[sep:]))
+		b.refreshMonitors()
 	case cmdStatus:
-		b.status(&update.Message)
+		b.status(ctx, &update.Message)
 	case cmdStatusFull:
-		b.statusFull(&update.Message)
+		b.statusFull(ctx, &update.Message)
+	case cmdStats:
+		b.stats(ctx, &update.Message)
 	case cmdVote:
-		b.vote(&update.Message)
+		b.vote(ctx, &update.Message)
+	case cmdCard:
+		b.card(ctx, &update.Message, strings.TrimSpace(update.Message.Text[sep:]))
 
+	case cmdDeadline:
+		b.deadline(ctx, &update.Message, strings.TrimSpace(update.Message.Text[sep:]))
+		b.refreshMonitors()
 	case cmdReboot:
-		b.reboot(&update.Message)
+		b.reboot(ctx, &update.Message)
 	case cmdMonitor:
-		b.monitor(&update.Message)
+		b.monitor(ctx, &update.Message)
 	case cmdRemove:
-		b.remove(&update.Message, strings.TrimSpace(update.Message.Text[sep:]))
-		b.monitorCh <- struct{}{}
+		b.remove(ctx, &update.Message, strings.TrimSpace(update.Message.Text[sep:]))
+		b.refreshMonitors()
 	case cmdReset:
-		b.reset(&update.Message)
-		b.monitorCh <- struct{}{}
+		b.reset(ctx, &update.Message)
+		b.refreshMonitors()
+	case cmdPoster:
+		b.poster(ctx, &update.Message, strings.TrimSpace(update.Message.Text[sep:]))
+	case cmdTrailer:
+		b.trailer(ctx, &update.Message, strings.TrimSpace(update.Message.Text[sep:]))
+	case cmdBroadcast:
+		b.broadcast(ctx, &update.Message, strings.TrimSpace(update.Message.Text[sep:]))
+	case cmdAudit:
+		b.audit(ctx, &update.Message, strings.TrimSpace(update.Message.Text[sep:]))
+	case cmdExport:
+		b.export(ctx, &update.Message, strings.TrimSpace(update.Message.Text[sep:]))
+	case cmdImport:
+		b.importFilms(ctx, &update.Message, strings.TrimSpace(update.Message.Text[sep:]))
+	case cmdScreening:
+		b.screening(ctx, &update.Message, strings.TrimSpace(update.Message.Text[sep:]))
+	case cmdAttended:
+		b.attended(ctx, &update.Message, strings.TrimSpace(update.Message.Text[sep:]))
 	}
 }
 
-func (b *Bot) help(msg *tgclient.Message) {
-	var text string
+func (b *Bot) help(ctx context.Context, msg *tgclient.Message) {
+	loc := b.locale(msg.From)
+	text := loc.text("help.user")
 	if b.isAdmin(msg.From.Id) {
-		text = msgHelpAdmin
-	} else {
-		text = msgHelp
+		text += "\n\n" + loc.text("help.admin")
 	}
 
-	if err := b.client.Answer(msg, text); err != nil {
+	if err := b.client.Answer(ctx, msg, text); err != nil {
 		slog.Error(err.Error())
 	}
 }
 
-func (b *Bot) addFilm(msg *tgclient.Message, film string) {
+func (b *Bot) addFilm(ctx context.Context, msg *tgclient.Message, film string) {
 	if film == "" {
-		if err := b.client.Answer(
-			msg,
-			"Invalid film name 🤡\n<span class=\"tg-spoiler\">Usage: /add Зелёный слоник 2</span>",
-		); err != nil {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("add.usage")); err != nil {
 			slog.Error(err.Error())
 		}
 		return
 	}
+	b.ensureUser(msg.From)
 	if err := b.storage.AddFilm(msg.From.Id, film); err != nil {
 		slog.Error("Faield to handle addFilm: " + err.Error())
 	} else {
-		if err := b.client.Answer(msg, fmt.Sprintf("\"%s\" добавлен в список 📋✍️", film)); err != nil {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).format("add.added", msgData{Film: storage.FilmStat{Name: film}})); err != nil {
 			slog.Error(err.Error())
 		}
 	}
 }
 
-func (b *Bot) register(msg *tgclient.Message) {
-	added, err := b.storage.Register(msg.From.Id, msg.From.Name, msg.From.Username)
+func (b *Bot) register(ctx context.Context, msg *tgclient.Message) {
+	added, err := b.storage.Register(msg.From.Id, profile(msg.From))
 	if err != nil {
 		slog.Error("Failed to register user: " + err.Error())
 	}
-	if added {
-		if err := b.client.Answer(msg, "<b>Welcome to the club, buddy</b> 🍑👋"); err != nil {
-			slog.Error(err.Error())
-		}
-	} else {
-		if err := b.client.Answer(msg, "Ты уже смешарик..."); err != nil {
-			slog.Error(err.Error())
+	if err := b.storage.Rejoin(msg.From.Id); err != nil {
+		slog.Error("Failed to bring user back: " + err.Error())
+	}
+	if msg.Chat.Type == tgclient.ChatTypePrivate {
+		if err := b.storage.SetInactive(msg.From.Id, false); err != nil {
+			slog.Error("Failed to mark user active: " + err.Error())
 		}
 	}
+	text := b.locale(msg.From).text("start.again")
+	if added {
+		text = b.locale(msg.From).text("start.welcome")
+	}
+	if err := b.client.Answer(ctx, msg, text); err != nil {
+		slog.Error(err.Error())
+	}
 }
 
-func (b *Bot) status(msg *tgclient.Message) {
+func (b *Bot) status(ctx context.Context, msg *tgclient.Message) {
 	stats := b.storage.Status()
 
 	var vote int
 	if msg.Chat.Type == tgclient.ChatTypePrivate {
 		vote = b.storage.GetVote(msg.From.Id)
 	}
-	text := statusText(stats, vote)
+	text := statusText(b.locale(msg.From), stats, vote)
 
-	if err := b.client.Answer(msg, text); err != nil {
+	if err := b.client.Answer(ctx, msg, text); err != nil {
 		slog.Error(fmt.Sprintf("failed to handle status requst: %s", err.Error()))
 	}
 }
 
-func (b *Bot) statusFull(msg *tgclient.Message) {
+func (b *Bot) statusFull(ctx context.Context, msg *tgclient.Message) {
+	loc := b.locale(msg.From)
 	stats := b.storage.StatusFull()
 	if len(stats) == 0 {
-		if err := b.client.Answer(msg, "Фильмов пока нет 💀"); err != nil {
+		if err := b.client.Answer(ctx, msg, loc.text("status.empty")); err != nil {
 			slog.Error(err.Error())
 		}
 		return
 	}
 
-	builder := strings.Builder{}
-	for i := range stats {
-		builder.WriteString(fmt.Sprintf(
-			"🔸 <b>%s</b> by <a href=\"https://t.me/%s\">%s</a> - %d:\n",
-			stats[i].Name, stats[i].AddedBy.Username, stats[i].AddedBy.Name, stats[i].Votes,
-		))
-		for j := range stats[i].Voters {
-			builder.WriteString(fmt.Sprintf(
-				"<a href=\"https://t.me/%s\">%s</a>\n",
-				stats[i].Voters[j].Username, stats[i].Voters[j].Name,
-			))
+	if b.storage.Rules().MembersOnly {
+		for i := range stats {
+			for _, v := range stats[i].Voters {
+				b.checkMember(ctx, v.Id)
+			}
 		}
+		stats = b.storage.StatusFull()
 	}
 
-	if err := b.client.Answer(msg, builder.String()); err != nil {
+	text := statusFullText(loc, stats, b.storage.Rules())
+	if err := b.client.Answer(ctx, msg, text); err != nil {
 		slog.Error(fmt.Sprintf("failed to handle status requst: %s", err.Error()))
 	}
 }
 
-func (b *Bot) vote(msg *tgclient.Message) {
-	stats := b.storage.Status()
-
-	n := len(stats)
-	keyboard := tgclient.InlineKeyboardMarkup{
-		Keyboard: make([][]tgclient.InlineKeyboardButton, n+1),
+// statusFullText lists films with their voters and the rules votes are counted by
+func statusFullText(loc locale, stats []storage.FilmStat, rules storage.Rules) string {
+	builder := strings.Builder{}
+	for i := range stats {
+		builder.WriteString(loc.format("status.film", msgData{Film: stats[i]}) + "\n")
+		for _, v := range stats[i].Voters {
+			data := msgData{User: v.UserInfo, UserId: v.Id, Count: v.Weight}
+			switch {
+			case v.Err != nil:
+				data.HTML = template.HTML(ineligibleText(loc, v.Err))
+				builder.WriteString(loc.format("status.voter_ineligible", data) + "\n")
+			case v.Weight > 1:
+				builder.WriteString(loc.format("status.voter_weighted", data) + "\n")
+			default:
+				builder.WriteString(loc.format("status.voter", data) + "\n")
+			}
+		}
 	}
-	for i := range n {
-		keyboard.Keyboard[i] = []tgclient.InlineKeyboardButton{{
-			Text: stats[i].Name,
-			Data: fmt.Sprintf("%s%d", prefVote, stats[i].Id),
-		}}
+	if text := rulesText(loc, rules); text != "" {
+		builder.WriteString("\n" + text)
 	}
-	keyboard.Keyboard[n] = []tgclient.InlineKeyboardButton{{
-		Text: "❌",
-		Data: prefVote + "0",
-	}}
+	return builder.String()
+}
 
-	if err := b.client.SendInlineKeyboard(msg.From.Id, "🤔🤔🤔🤔", keyboard); err != nil {
+func (b *Bot) vote(ctx context.Context, msg *tgclient.Message) {
+	b.ensureUser(msg.From)
+	if b.storage.GetUser(msg.From.Id).Quit {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("vote.quit")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+	err := b.sendVoteKeyboard(ctx, msg.From.Id, b.locale(msg.From).text("vote.prompt"))
+	if tgclient.IsBlocked(err) {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("vote.start_first")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+	if err != nil {
 		slog.Error("Failed to send voting message: " + err.Error())
 	}
 }
 
+// sendVoteKeyboard sends a fresh voting keyboard to the user's DM.
+// Users who blocked the bot are marked inactive.
+func (b *Bot) sendVoteKeyboard(ctx context.Context, userID int64, text string) error {
+	keyboard := b.voteKeyboard(b.storage.Status())
+
+	_, err := b.client.SendInlineKeyboard(ctx, userID, text, keyboard)
+	if tgclient.IsBlocked(err) {
+		b.markInactive(userID)
+	}
+	return err
+}
+
+// markInactive remembers that the user blocked the bot, so mass DMs skip them
+func (b *Bot) markInactive(userID int64) {
+	slog.Info(fmt.Sprintf("user %d blocked the bot", userID))
+	if err := b.storage.SetInactive(userID, true); err != nil && !errors.Is(err, storage.ErrUnknownUser) {
+		slog.Error("Failed to mark user inactive: " + err.Error())
+	}
+}
+
 // admin command
-func (b *Bot) remove(msg *tgclient.Message, film string) {
+func (b *Bot) remove(ctx context.Context, msg *tgclient.Message, film string) {
 	if !b.isAdmin(msg.From.Id) {
-		if err := b.client.Answer(msg, "Кыш 😡"); err != nil {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("common.not_admin")); err != nil {
 			slog.Error(err.Error())
 		}
 		return
 	}
 
-	found, err := b.storage.RemoveFilm(film)
+	found, affected, err := b.storage.RemoveFilm(msg.From.Id, film)
 	if err != nil {
 		slog.Error("failed to remove film: " + err.Error())
-		if err := b.client.Answer(msg, err.Error()); err != nil {
+		if err := b.client.Answer(ctx, msg, err.Error()); err != nil {
 			slog.Error(err.Error())
 		}
+		return
 	}
 
 	if found {
-		if err := b.client.Answer(msg, film+" removed"); err != nil {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).format("remove.done", msgData{Film: storage.FilmStat{Name: film}, Count: len(affected)})); err != nil {
 			slog.Error(err.Error())
 		}
+		b.notifyRevote(ctx, film, affected)
 	} else {
-		if err := b.client.Answer(msg, film+" wasn't found"); err != nil {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).format("common.not_found", msgData{Film: storage.FilmStat{Name: film}})); err != nil {
 			slog.Error(err.Error())
 		}
 	}
 }
 
+// notifyRevote asks voters of a removed film to vote again
+func (b *Bot) notifyRevote(ctx context.Context, film string, users []int64) {
+	for _, id := range users {
+		if err := b.sendVoteKeyboard(ctx, id, b.localeOf(id).format("vote.revote", msgData{Film: storage.FilmStat{Name: film}})); err != nil {
+			slog.Error(fmt.Sprintf("failed to ask user %d to revote: %s", id, err.Error()))
+		}
+	}
+}
+
 // admin command
-func (b *Bot) reset(msg *tgclient.Message) {
+func (b *Bot) reset(ctx context.Context, msg *tgclient.Message) {
 	if !b.isAdmin(msg.From.Id) {
-		if err := b.client.Answer(msg, "Кыш 😡"); err != nil {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("common.not_admin")); err != nil {
 			slog.Error(err.Error())
 		}
 		return
 	}
 
-	b.storage.ResetVotes()
-	if err := b.client.Answer(msg, "Голоса сброшены"); err != nil {
+	// the closed session is recorded with the votes that count now
+	b.refreshVoters(ctx, 0)
+	text := b.locale(msg.From).text("reset.done")
+	if err := b.storage.ResetVotes(msg.From.Id); err != nil {
+		slog.Error("failed to reset votes: " + err.Error())
+		text = b.locale(msg.From).format("reset.failed", msgData{Text: err.Error()})
+	}
+	if err := b.client.Answer(ctx, msg, text); err != nil {
 		slog.Error(err.Error())
 	}
 }
 
 // admin command
-func (b *Bot) monitor(msg *tgclient.Message) {
+func (b *Bot) monitor(ctx context.Context, msg *tgclient.Message) {
 	if !b.isAdmin(msg.From.Id) {
-		if err := b.client.Answer(msg, "Кыш 😡"); err != nil {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("common.not_admin")); err != nil {
 			slog.Error(err.Error())
 		}
 		return
 	}
 
-	var text string
 	stats := b.storage.Status()
-	if len(stats) == 0 {
-		text = "Фильмов пока нет 💀"
-	} else {
-		first, second := getPositions(stats)
-		builder := strings.Builder{}
-		for i := range stats {
-			builder.WriteString(fmt.Sprintf(
-				"%s<b>%s</b>: %d\n",
-				getEmoj(i, first, second), stats[i].Name, stats[i].Votes,
-			))
-		}
-		text = builder.String()
-	}
+	b.announcePosters(ctx, msg, stats)
 
-	m, err := b.client.AnswerWithResult(msg, text)
+	text := b.dashboardText(stats, time.Now())
+	keyboard := b.voteKeyboard(stats)
+
+	m, err := b.client.AnswerWithResult(ctx, msg, text, &keyboard)
 	if err != nil {
 		slog.Error(err.Error())
+		return
+	}
+
+	b.storage.AddMonitor(m.Chat.Id, m.Id)
+}
+
+// admin command
+func (b *Bot) deadline(ctx context.Context, msg *tgclient.Message, arg string) {
+	if !b.isAdmin(msg.From.Id) {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("common.not_admin")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	var deadline time.Time
+	if arg != "" {
+		var err error
+		deadline, err = time.ParseInLocation(deadlineLayout, arg, time.Local)
+		if err != nil {
+			if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("deadline.usage")); err != nil {
+				slog.Error(err.Error())
+			}
+			return
+		}
 	}
 
-	b.storage.SetMonitor(m.Chat.Id, m.Id)
+	if err := b.storage.SetDeadline(deadline); err != nil {
+		slog.Error("failed to set deadline: " + err.Error())
+	}
+
+	text := b.locale(msg.From).text("deadline.cleared")
+	if !deadline.IsZero() {
+		text = b.locale(msg.From).format("deadline.set", msgData{Deadline: deadline})
+	}
+	if err := b.client.Answer(ctx, msg, text); err != nil {
+		slog.Error(err.Error())
+	}
 }
 
 // admin command
-func (b *Bot) reboot(msg *tgclient.Message) {
+func (b *Bot) reboot(ctx context.Context, msg *tgclient.Message) {
 	if !b.isAdmin(msg.From.Id) {
-		if err := b.client.Answer(msg, "Кыш 😡"); err != nil {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("common.not_admin")); err != nil {
 			slog.Error(err.Error())
 		}
 		return
@@ -276,3 +406,39 @@ func (b *Bot) reboot(msg *tgclient.Message) {
 		b.Stop()
 	}
 }
+
+func (b *Bot) lang(ctx context.Context, msg *tgclient.Message, arg string) {
+	langs := b.messages.langs()
+	names := make([]string, len(langs))
+	for i, lang := range langs {
+		names[i] = fmt.Sprintf("%s (%s)", lang, b.messages.render(lang, "language", msgData{}))
+	}
+
+	var lang string
+	switch arg {
+	case "":
+		loc := b.locale(msg.From)
+		if err := b.client.Answer(ctx, msg, loc.format("lang.current", msgData{HTML: template.HTML(loc.text("language")), List: names})); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	case "auto":
+	default:
+		var ok bool
+		if lang, ok = b.messages.match(arg); !ok {
+			if err := b.client.Answer(ctx, msg, b.locale(msg.From).format("lang.unknown", msgData{List: names})); err != nil {
+				slog.Error(err.Error())
+			}
+			return
+		}
+	}
+
+	b.ensureUser(msg.From)
+	if err := b.storage.SetLanguage(msg.From.Id, lang); err != nil {
+		slog.Error("failed to set language: " + err.Error())
+	}
+	loc := b.locale(msg.From)
+	if err := b.client.Answer(ctx, msg, loc.format("lang.set", msgData{HTML: template.HTML(loc.text("language"))})); err != nil {
+		slog.Error(err.Error())
+	}
+}
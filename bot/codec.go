@@ -0,0 +1,113 @@
+package bot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Callback data layout (Telegram allows at most 64 bytes):
+//
+//	<version><action>.<session>.<arg>.<mac>
+//
+// session and arg are base36, mac is a truncated HMAC-SHA256 of everything before it.
+const (
+	callbackVersion = '1'
+	callbackSep     = "."
+	callbackMacSize = 8
+	callbackMaxLen  = 64
+)
+
+type action byte
+
+const (
+	actVote            action = 'v'
+	actRetract         action = 'r'
+	actImport          action = 'i'
+	actImportCancel    action = 'I'
+	actGoing           action = 'G'
+	actMaybe           action = 'M'
+	actNotGoing        action = 'N'
+	actBroadcast       action = 'b'
+	actBroadcastCancel action = 'B'
+	actForget          action = 'f'
+	actForgetCancel    action = 'F'
+)
+
+var (
+	errBadCallback      = errors.New("malformed callback data")
+	errTamperedCallback = errors.New("callback signature mismatch")
+	errOutdatedCallback = errors.New("outdated callback data")
+)
+
+type callbackData struct {
+	Action  action
+	Session int
+	Arg     int64
+}
+
+type callbackCodec struct {
+	key []byte
+}
+
+func newCallbackCodec(secret string) *callbackCodec {
+	key := sha256.Sum256([]byte("callback:" + secret))
+	return &callbackCodec{key: key[:]}
+}
+
+func (c *callbackCodec) Encode(d callbackData) string {
+	payload := string([]byte{callbackVersion, byte(d.Action)}) +
+		callbackSep + strconv.FormatInt(int64(d.Session), 36) +
+		callbackSep + strconv.FormatInt(d.Arg, 36)
+
+	return payload + callbackSep + c.sign(payload)
+}
+
+// Decode checks version and signature. Session freshness is up to the caller.
+func (c *callbackCodec) Decode(s string) (callbackData, error) {
+	if len(s) > callbackMaxLen {
+		return callbackData{}, errBadCallback
+	}
+
+	i := strings.LastIndex(s, callbackSep)
+	if i < 0 {
+		return callbackData{}, errBadCallback
+	}
+	payload, mac := s[:i], s[i+1:]
+
+	parts := strings.Split(payload, callbackSep)
+	if len(parts) != 3 || len(parts[0]) != 2 {
+		return callbackData{}, errBadCallback
+	}
+	if parts[0][0] != callbackVersion {
+		return callbackData{}, errOutdatedCallback
+	}
+	if !hmac.Equal([]byte(mac), []byte(c.sign(payload))) {
+		return callbackData{}, errTamperedCallback
+	}
+
+	session, err := strconv.ParseInt(parts[1], 36, 64)
+	if err != nil {
+		return callbackData{}, fmt.Errorf("%w: session: %w", errBadCallback, err)
+	}
+	arg, err := strconv.ParseInt(parts[2], 36, 64)
+	if err != nil {
+		return callbackData{}, fmt.Errorf("%w: arg: %w", errBadCallback, err)
+	}
+
+	return callbackData{
+		Action:  action(parts[0][1]),
+		Session: int(session),
+		Arg:     arg,
+	}, nil
+}
+
+func (c *callbackCodec) sign(payload string) string {
+	h := hmac.New(sha256.New, c.key)
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:callbackMacSize])
+}
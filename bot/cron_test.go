@@ -0,0 +1,84 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-a * * * *",
+		"@yearly",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		res, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	// 2024-01-01 is Monday
+	tests := []struct {
+		name string
+		expr string
+		from string
+		want string
+	}{
+		{"every minute", "* * * * *", "2024-01-01 10:07", "2024-01-01 10:08"},
+		{"step", "*/15 * * * *", "2024-01-01 10:07", "2024-01-01 10:15"},
+		{"step to the next hour", "*/15 * * * *", "2024-01-01 10:45", "2024-01-01 11:00"},
+		{"range step", "10-40/10 9 * * *", "2024-01-01 09:35", "2024-01-01 09:40"},
+		{"list", "0 9,18 * * *", "2024-01-01 09:00", "2024-01-01 18:00"},
+		{"alias", "@weekly", "2024-01-01 00:00", "2024-01-07 00:00"},
+		{"0 is Sunday", "0 9 * * 0", "2024-01-01 10:00", "2024-01-07 09:00"},
+		{"7 is Sunday", "0 9 * * 7", "2024-01-01 10:00", "2024-01-07 09:00"},
+		{"weekdays", "0 9 * * 1-5", "2024-01-05 09:00", "2024-01-08 09:00"},
+		{"day of week only", "0 0 * * 1", "2024-01-01 00:00", "2024-01-08 00:00"},
+		{"day of month only", "0 0 13 * *", "2024-01-01 00:00", "2024-01-13 00:00"},
+		{"either day matches weekday", "0 0 13 * 5", "2024-01-01 00:00", "2024-01-05 00:00"},
+		{"either day matches date", "0 0 13 * 5", "2024-01-12 00:00", "2024-01-13 00:00"},
+		{"month rollover", "0 0 1 * *", "2024-01-15 00:00", "2024-02-01 00:00"},
+		{"short month skipped", "0 0 31 * *", "2024-01-31 00:00", "2024-03-31 00:00"},
+		{"year rollover", "30 23 * * *", "2024-12-31 23:30", "2025-01-01 23:30"},
+		{"month restricted", "0 0 1 6 *", "2024-07-01 00:00", "2025-06-01 00:00"},
+		{"leap day", "0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00"},
+		{"never", "0 0 30 2 *", "2024-01-01 00:00", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := spec.Next(at(tt.from))
+			if tt.want == "" {
+				if !got.IsZero() {
+					t.Errorf("Next = %v, want none", got)
+				}
+				return
+			}
+			if want := at(tt.want); !got.Equal(want) {
+				t.Errorf("Next = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"strings"
+	"time"
+	"vote/storage"
+)
+
+const memberCheckTTL = time.Minute * 10
+
+// checkMember refreshes the user's membership in the main chat if the rules need it.
+// Results are cached for memberCheckTTL, on errors the stored state is kept.
+func (b *Bot) checkMember(ctx context.Context, userID int64) {
+	b.refreshMember(ctx, userID, memberCheckTTL)
+}
+
+// refreshMember checks the membership unless it was checked within maxAge and reports if it changed
+func (b *Bot) refreshMember(ctx context.Context, userID int64, maxAge time.Duration) bool {
+	if !b.storage.Rules().MembersOnly {
+		return false
+	}
+
+	b.membersMu.Lock()
+	checked, ok := b.memberChecked[userID]
+	if ok && time.Since(checked) < maxAge {
+		b.membersMu.Unlock()
+		return false
+	}
+	b.memberChecked[userID] = time.Now()
+	b.membersMu.Unlock()
+
+	m, err := b.client.ChatMember(ctx, b.mainChatId, userID)
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to check membership of user %d: %s", userID, err.Error()))
+		return false
+	}
+	left := b.storage.GetUser(userID).Left
+	if err := b.storage.SetMember(userID, m.Member()); err != nil {
+		if !errors.Is(err, storage.ErrUnknownUser) {
+			slog.Error("failed to save membership: " + err.Error())
+		}
+		return false
+	}
+	return left == m.Member()
+}
+
+// refreshVoters refreshes the membership of users who voted, so the tally doesn't
+// count members who left the main chat since their vote. It reports if anything changed.
+func (b *Bot) refreshVoters(ctx context.Context, maxAge time.Duration) bool {
+	if !b.storage.Rules().MembersOnly {
+		return false
+	}
+	changed := false
+	for id, info := range b.storage.Snapshot().Users {
+		if info.Vote != 0 && b.refreshMember(ctx, id, maxAge) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// checkVoters keeps the membership of voters fresh for /status and dashboards
+func (b *Bot) checkVoters(ctx context.Context, now time.Time) {
+	if b.refreshVoters(ctx, memberCheckTTL) {
+		b.refreshMonitors()
+	}
+}
+
+// ineligibleText explains why a vote is not counted
+func ineligibleText(loc locale, err error) string {
+	switch {
+	case errors.Is(err, storage.ErrNotMember):
+		return loc.text("eligibility.not_member")
+	case errors.Is(err, storage.ErrNoAttendance):
+		return loc.text("eligibility.no_attendance")
+	}
+	return loc.text("eligibility.other")
+}
+
+// rulesText describes the rules for /status_full, empty if everyone votes equally
+func rulesText(loc locale, r storage.Rules) string {
+	var rules []string
+	if r.MembersOnly {
+		rules = append(rules, loc.text("rules.members"))
+	}
+	if r.Of > 0 {
+		rules = append(rules, loc.format("rules.attendance", msgData{Rules: r}))
+	}
+	if r.LoserBonus > 0 {
+		rules = append(rules, loc.format("rules.bonus", msgData{Rules: r}))
+	}
+	if len(rules) == 0 {
+		return ""
+	}
+	return loc.format("rules.title", msgData{HTML: template.HTML(strings.Join(rules, ", "))})
+}
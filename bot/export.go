@@ -0,0 +1,411 @@
+package bot
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"vote/storage"
+	"vote/tgclient"
+)
+
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+
+	importPreviewSize = 20
+)
+
+type exportData struct {
+	Exported time.Time       `json:"exported"`
+	Session  int             `json:"session"`
+	Films    []exportFilm    `json:"films"`
+	Users    []exportUser    `json:"users"`
+	History  []storage.Event `json:"history"`
+}
+
+type exportFilm struct {
+	Id      int     `json:"id"`
+	Name    string  `json:"name"`
+	AddedBy int64   `json:"added_by"`
+	Votes   int     `json:"votes"`
+	Voters  []int64 `json:"voters"`
+	Poster  string  `json:"poster,omitempty"`
+	Trailer string  `json:"trailer,omitempty"`
+}
+
+type exportUser struct {
+	Id           int64  `json:"id"`
+	Name         string `json:"name"`
+	LastName     string `json:"last_name,omitempty"`
+	Username     string `json:"username"`
+	LanguageCode string `json:"language_code,omitempty"`
+	Vote         int    `json:"vote"`
+	Inactive     bool   `json:"inactive,omitempty"`
+	Quit         bool   `json:"quit,omitempty"`
+	LastSeen     int64  `json:"last_seen,omitempty"`
+}
+
+// admin command
+func (b *Bot) export(ctx context.Context, msg *tgclient.Message, format string) {
+	if !b.isAdmin(msg.From.Id) {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("common.not_admin")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	if format == "" {
+		format = formatJSON
+	}
+
+	data := newExportData(b.storage.Snapshot())
+	history, err := b.storage.Events(nil, 0)
+	if err != nil {
+		slog.Error("failed to read events for export: " + err.Error())
+	}
+	data.History = history
+
+	var content []byte
+	ext := format
+	switch format {
+	case formatJSON:
+		content, err = json.MarshalIndent(data, "", "  ")
+	case formatCSV:
+		content, err = data.csv()
+		ext = "zip"
+	default:
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("export.usage")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+	if err != nil {
+		slog.Error("failed to export data: " + err.Error())
+		return
+	}
+
+	// personal data goes to DM only
+	name := fmt.Sprintf("vote-%s.%s", data.Exported.Format("2006-01-02"), ext)
+	if _, err := b.client.SendDocument(
+		ctx, msg.From.Id, 0,
+		tgclient.InputFile{Name: name, Data: content},
+		b.locale(msg.From).format("export.caption", msgData{Count: len(data.Films), Total: len(data.Users)}),
+	); err != nil {
+		slog.Error("failed to send export: " + err.Error())
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("export.dm_failed")); err != nil {
+			slog.Error(err.Error())
+		}
+	}
+}
+
+func newExportData(snap storage.Snapshot) exportData {
+	data := exportData{
+		Exported: time.Now(),
+		Session:  snap.Session,
+		Films:    make([]exportFilm, 0, len(snap.Films)),
+		Users:    make([]exportUser, 0, len(snap.Users)),
+	}
+
+	idx := map[int]int{}
+	for id, info := range snap.Films {
+		idx[id] = len(data.Films)
+		data.Films = append(data.Films, exportFilm{
+			Id:      id,
+			Name:    info.Name,
+			AddedBy: info.Added,
+			Voters:  []int64{},
+			Poster:  info.Poster,
+			Trailer: info.Trailer,
+		})
+	}
+	for id, info := range snap.Users {
+		data.Users = append(data.Users, exportUser{
+			Id:           id,
+			Name:         info.Name,
+			LastName:     info.LastName,
+			Username:     info.Username,
+			LanguageCode: info.LanguageCode,
+			Vote:         info.Vote,
+			Inactive:     info.Inactive,
+			Quit:         info.Quit,
+			LastSeen:     info.LastSeen,
+		})
+		if i, ok := idx[info.Vote]; ok {
+			data.Films[i].Votes++
+			data.Films[i].Voters = append(data.Films[i].Voters, id)
+		}
+	}
+
+	slices.SortFunc(data.Films, func(a, b exportFilm) int { return cmp.Compare(a.Id, b.Id) })
+	slices.SortFunc(data.Users, func(a, b exportUser) int { return cmp.Compare(a.Id, b.Id) })
+	for i := range data.Films {
+		slices.Sort(data.Films[i].Voters)
+	}
+
+	return data
+}
+
+// csv packs films, users and history as separate tables into a zip archive
+func (d exportData) csv() ([]byte, error) {
+	names := map[int64]string{}
+	for _, u := range d.Users {
+		names[u.Id] = u.Name
+	}
+
+	films := [][]string{{"id", "name", "added_by", "votes", "voters"}}
+	for _, f := range d.Films {
+		voters := make([]string, len(f.Voters))
+		for i, id := range f.Voters {
+			voters[i] = fmt.Sprintf("%s (%d)", names[id], id)
+		}
+		films = append(films, []string{
+			strconv.Itoa(f.Id),
+			f.Name,
+			strconv.FormatInt(f.AddedBy, 10),
+			strconv.Itoa(f.Votes),
+			strings.Join(voters, "; "),
+		})
+	}
+
+	users := [][]string{{"id", "name", "last_name", "username", "language_code", "vote", "inactive", "quit", "last_seen"}}
+	for _, u := range d.Users {
+		users = append(users, []string{
+			strconv.FormatInt(u.Id, 10),
+			u.Name,
+			u.LastName,
+			u.Username,
+			u.LanguageCode,
+			strconv.Itoa(u.Vote),
+			strconv.FormatBool(u.Inactive),
+			strconv.FormatBool(u.Quit),
+			csvTime(u.LastSeen),
+		})
+	}
+
+	history := [][]string{{"time", "type", "user", "film", "name", "username", "before", "after"}}
+	for _, e := range d.History {
+		history = append(history, []string{
+			csvTime(e.Time),
+			string(e.Type),
+			strconv.FormatInt(e.User, 10),
+			strconv.Itoa(e.Film),
+			e.Name,
+			e.Username,
+			strconv.Itoa(e.Before),
+			strconv.Itoa(e.After),
+		})
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, table := range []struct {
+		name    string
+		records [][]string
+	}{
+		{"films.csv", films},
+		{"users.csv", users},
+		{"history.csv", history},
+	} {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: table.name, Method: zip.Deflate, Modified: d.Exported})
+		if err != nil {
+			return nil, err
+		}
+		if err := csv.NewWriter(f).WriteAll(table.records); err != nil {
+			return nil, fmt.Errorf("%s: %w", table.name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func csvTime(unix int64) string {
+	if unix == 0 {
+		return ""
+	}
+	return time.Unix(unix, 0).UTC().Format(time.RFC3339)
+}
+
+// admin command
+func (b *Bot) importFilms(ctx context.Context, msg *tgclient.Message, arg string) {
+	if !b.isAdmin(msg.From.Id) {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("common.not_admin")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	var content []byte
+	switch {
+	case msg.ReplyTo != nil && msg.ReplyTo.Document != nil:
+		var err error
+		content, err = b.client.DownloadFile(ctx, msg.ReplyTo.Document.FileId)
+		if err != nil {
+			slog.Error("failed to download import: " + err.Error())
+			if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("import.download_failed")); err != nil {
+				slog.Error(err.Error())
+			}
+			return
+		}
+	case arg != "":
+		content = []byte(arg)
+	default:
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("import.usage")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	names, err := parseImport(content)
+	if err != nil {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).format("import.parse_failed", msgData{Text: err.Error()})); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	exists := map[string]struct{}{}
+	for _, stat := range b.storage.Status() {
+		exists[stat.Name] = struct{}{}
+	}
+	names = slices.DeleteFunc(names, func(name string) bool {
+		_, ok := exists[name]
+		return ok
+	})
+	if len(names) == 0 {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("import.nothing_new")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	loc := b.locale(msg.From)
+	id := b.imports.add(names)
+	session := b.storage.Session()
+	keyboard := tgclient.InlineKeyboardMarkup{Keyboard: [][]tgclient.InlineKeyboardButton{{
+		{Text: loc.text("import.confirm"), Data: b.codec.Encode(callbackData{Action: actImport, Session: session, Arg: id})},
+		{Text: loc.text("common.cancel"), Data: b.codec.Encode(callbackData{Action: actImportCancel, Session: session, Arg: id})},
+	}}}
+	if _, err := b.client.AnswerWithResult(ctx, msg, importPreview(loc, names), &keyboard); err != nil {
+		slog.Error("failed to send import preview: " + err.Error())
+	}
+}
+
+func importPreview(loc locale, names []string) string {
+	builder := strings.Builder{}
+	builder.WriteString(loc.format("import.preview", msgData{Count: len(names)}) + "\n")
+	for i, name := range names {
+		if i == importPreviewSize {
+			builder.WriteString(loc.format("import.more", msgData{Count: len(names) - i}) + "\n")
+			break
+		}
+		builder.WriteString(loc.format("import.film", msgData{Film: storage.FilmStat{Name: name}}) + "\n")
+	}
+	return builder.String()
+}
+
+func (b *Bot) processImport(ctx context.Context, update *tgclient.Update, data callbackData) {
+	if !b.isAdmin(update.Callback.From.Id) {
+		b.answerCallback(ctx, update, b.locale(update.Callback.From).text("common.not_admin"), false)
+		return
+	}
+
+	loc := b.locale(update.Callback.From)
+	names, ok := b.imports.take(data.Arg)
+	if !ok {
+		b.answerCallback(ctx, update, loc.text("import.handled"), false)
+		return
+	}
+
+	var text string
+	if data.Action == actImportCancel {
+		text = loc.text("import.cancelled")
+	} else {
+		added, err := b.storage.AddFilms(update.Callback.From.Id, names)
+		if err != nil {
+			slog.Error("failed to import films: " + err.Error())
+		}
+		text = loc.format("import.added", msgData{Count: added})
+		b.refreshMonitors()
+	}
+
+	if err := b.client.EditMessage(
+		ctx,
+		update.Callback.Message.Chat.Id,
+		update.Callback.Message.Id,
+		text,
+		tgclient.InlineKeyboardMarkup{Keyboard: [][]tgclient.InlineKeyboardButton{}},
+	); err != nil {
+		slog.Error("failed to edit import preview: " + err.Error())
+	}
+	b.answerCallback(ctx, update, text, false)
+}
+
+// parseImport accepts /export JSON, CSV with a "name" column or plain titles one per line
+func parseImport(content []byte) ([]string, error) {
+	content = bytes.TrimSpace(bytes.TrimPrefix(content, []byte("\xef\xbb\xbf")))
+
+	var names []string
+	if bytes.HasPrefix(content, []byte("{")) {
+		var data exportData
+		if err := json.Unmarshal(content, &data); err != nil {
+			return nil, err
+		}
+		for _, f := range data.Films {
+			names = append(names, f.Name)
+		}
+	} else if col, ok := csvNameColumn(content); ok {
+		records, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range records[1:] {
+			if col < len(rec) {
+				names = append(names, rec[col])
+			}
+		}
+	} else {
+		sc := bufio.NewScanner(bytes.NewReader(content))
+		for sc.Scan() {
+			names = append(names, sc.Text())
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	uniq := map[string]struct{}{}
+	res := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if _, ok := uniq[name]; ok || name == "" {
+			continue
+		}
+		uniq[name] = struct{}{}
+		res = append(res, name)
+	}
+
+	return res, nil
+}
+
+// csvNameColumn finds the "name" column in a CSV header
+func csvNameColumn(content []byte) (int, bool) {
+	header, err := csv.NewReader(bytes.NewReader(content)).Read()
+	if err != nil || len(header) < 2 {
+		return 0, false
+	}
+	i := slices.Index(header, "name")
+	return i, i >= 0
+}
@@ -0,0 +1,60 @@
+package bot
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"vote/storage"
+)
+
+func TestExportCSV(t *testing.T) {
+	data := newExportData(storage.Snapshot{
+		Users: map[int64]storage.UserInfo{
+			1<<40 + 1: {Name: "big id", Vote: 2},
+			1:         {Name: "user", Vote: 2},
+		},
+		Films: map[int]storage.FilmInfo{2: {Name: "film, with a comma", Added: 1}},
+	})
+	data.History = []storage.Event{
+		{Time: 1, Type: storage.EventAdd, User: 1, Film: 2, Name: "film, with a comma"},
+		{Time: 2, Type: storage.EventVote, User: 1, After: 2},
+	}
+
+	content, err := data.csv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := map[string]int{"films.csv": 2, "users.csv": 3, "history.csv": 3}
+	for _, f := range zr.File {
+		want, ok := rows[f.Name]
+		if !ok {
+			t.Errorf("unexpected file %s", f.Name)
+			continue
+		}
+		delete(rows, f.Name)
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(r).ReadAll()
+		r.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		if len(records) != want {
+			t.Errorf("%s has %d rows, want %d", f.Name, len(records), want)
+		}
+		if f.Name == "users.csv" && records[1][0] != "1" {
+			t.Errorf("users are not ordered by id: %v", records)
+		}
+	}
+	for name := range rows {
+		t.Errorf("%s is missing", name)
+	}
+}
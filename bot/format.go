@@ -0,0 +1,43 @@
+package bot
+
+import "strings"
+
+// Messages are sent with the HTML parse mode, so a film called "Tom & Jerry" breaks
+// the message unless every user-supplied value is escaped. Templates escape values
+// by themselves, Go code composes HTML with htmlBuilder.
+
+// Telegram knows only these named entities
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+// escape makes plain text safe to put into a message
+func escape(s string) string {
+	return htmlEscaper.Replace(s)
+}
+
+// htmlBuilder composes a message from plain text and formatting entities, text is escaped
+type htmlBuilder struct {
+	sb strings.Builder
+}
+
+func (h *htmlBuilder) bold(s string) *htmlBuilder {
+	return h.tag("<b>", s, "</b>")
+}
+
+func (h *htmlBuilder) spoiler(s string) *htmlBuilder {
+	return h.tag(`<span class="tg-spoiler">`, s, "</span>")
+}
+
+func (h *htmlBuilder) link(href string, s string) *htmlBuilder {
+	return h.tag(`<a href="`+escape(href)+`">`, s, "</a>")
+}
+
+func (h *htmlBuilder) tag(open string, s string, end string) *htmlBuilder {
+	h.sb.WriteString(open)
+	h.sb.WriteString(escape(s))
+	h.sb.WriteString(end)
+	return h
+}
+
+func (h *htmlBuilder) String() string {
+	return h.sb.String()
+}
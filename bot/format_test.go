@@ -0,0 +1,194 @@
+package bot
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"vote/storage"
+)
+
+// tags Telegram accepts with the HTML parse mode and the attributes they may have
+var telegramTags = map[string]*regexp.Regexp{
+	"b":          nil,
+	"strong":     nil,
+	"i":          nil,
+	"em":         nil,
+	"u":          nil,
+	"ins":        nil,
+	"s":          nil,
+	"strike":     nil,
+	"del":        nil,
+	"tg-spoiler": nil,
+	"code":       regexp.MustCompile(`^class="language-[\w-]+"$`),
+	"pre":        nil,
+	"blockquote": regexp.MustCompile(`^expandable$`),
+	"span":       regexp.MustCompile(`^class="tg-spoiler"$`),
+	"a":          regexp.MustCompile(`^href="[^"<>]*"$`),
+}
+
+// named ones Telegram knows and numeric ones
+var telegramEntity = regexp.MustCompile(`^&(lt|gt|amp|quot|#[0-9]+|#x[0-9a-fA-F]+);`)
+
+// checkTelegramHTML reports the first thing Telegram would reject in the message
+func checkTelegramHTML(s string) error {
+	var open []string
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '&':
+			if !telegramEntity.MatchString(s[i:]) {
+				return fmt.Errorf("bare & at %d", i)
+			}
+		case '>':
+			return fmt.Errorf("bare > at %d", i)
+		case '<':
+			end := strings.IndexByte(s[i:], '>')
+			if end < 0 {
+				return fmt.Errorf("bare < at %d", i)
+			}
+			tag := s[i+1 : i+end]
+			i += end
+			if name, ok := strings.CutPrefix(tag, "/"); ok {
+				if len(open) == 0 || open[len(open)-1] != name {
+					return fmt.Errorf("unexpected </%s>, open %v", name, open)
+				}
+				open = open[:len(open)-1]
+				continue
+			}
+			name, attrs, _ := strings.Cut(tag, " ")
+			allowed, ok := telegramTags[name]
+			if !ok {
+				return fmt.Errorf("unsupported tag <%s>", tag)
+			}
+			if attrs != "" && (allowed == nil || !allowed.MatchString(attrs)) {
+				return fmt.Errorf("unsupported attributes <%s>", tag)
+			}
+			open = append(open, name)
+		}
+	}
+	if len(open) > 0 {
+		return fmt.Errorf("unclosed %v", open)
+	}
+	return nil
+}
+
+func TestCheckTelegramHTML(t *testing.T) {
+	valid := []string{
+		"plain",
+		`<b>bold</b> &amp; <a href="https://t.me/x">link</a> &#39; &#x27;`,
+		`<span class="tg-spoiler"><i>a</i></span>`,
+	}
+	invalid := []string{
+		"Tom & Jerry",
+		"a > b",
+		"a < b",
+		"&nbsp;",
+		"<b>unclosed",
+		"<b><i>crossed</b></i>",
+		"<div>x</div>",
+		`<span class="other">x</span>`,
+		`<a href="x" onclick="y">x</a>`,
+	}
+	for _, s := range valid {
+		if err := checkTelegramHTML(s); err != nil {
+			t.Errorf("%q: %v", s, err)
+		}
+	}
+	for _, s := range invalid {
+		if checkTelegramHTML(s) == nil {
+			t.Errorf("%q is accepted", s)
+		}
+	}
+}
+
+// FuzzMessages renders every message and the HTML composed by Go code with
+// user-supplied values and checks that Telegram would parse the result
+func FuzzMessages(f *testing.F) {
+	f.Add("Tom & Jerry", "<b>Name</b>", "user_name", `"Cinema" <3`, "Bring snacks & drinks > 2")
+	f.Add("</a><a href=\"javascript:alert(1)\">", "&amp;", `x"y`, "&#", "<i>")
+	f.Add("{{.Text}}", "'", "javascript:alert(1)", "a\nb", "")
+
+	locales, err := fs.Sub(localesFS, "locales")
+	if err != nil {
+		f.Fatal(err)
+	}
+	c, err := loadCatalog(defaultLang, locales)
+	if err != nil {
+		f.Fatal(err)
+	}
+	if langs := c.langs(); !slices.Contains(langs, "ru") || !slices.Contains(langs, "en") {
+		f.Fatalf("languages %v", langs)
+	}
+
+	f.Fuzz(func(t *testing.T, film, name, username, place, text string) {
+		check := func(what string, msg string) {
+			t.Helper()
+			if err := checkTelegramHTML(msg); err != nil {
+				t.Errorf("%s: %v\n%s", what, err, msg)
+			}
+		}
+
+		user := storage.UserInfo{Name: name, LastName: name, Username: username}
+		data := sampleData
+		data.User = user
+		data.Film.Name = film
+		data.Film.AddedBy = user
+		data.Screening.Place = place
+		data.Screening.Film = film
+		data.Text = text
+		data.List = []string{name, film}
+		// a broadcast text is inserted as HTML once escaped
+		data.HTML = template.HTML(escape(text)) + userLink(1, user)
+
+		stats := []storage.FilmStat{{
+			Id: 1, Name: film, Votes: 3, Ballots: 2, AddedBy: user, AddedById: 1,
+			Voters: []storage.Voter{
+				{UserInfo: user, Id: 1, Weight: 1},
+				{UserInfo: user, Id: 2, Weight: 2},
+				{UserInfo: user, Id: 3, Err: storage.ErrNotMember},
+			},
+		}}
+		users := map[int64]storage.UserInfo{1: user}
+		films := map[int]string{1: film}
+		events := []storage.Event{
+			{Type: storage.EventRegister, User: 1, Name: name, Username: username},
+			{Type: storage.EventAdd, User: 1, Film: 1, Name: film},
+			{Type: storage.EventRemove, User: 1, Film: 1, Name: film},
+			{Type: storage.EventRename, User: 2, Film: 1, Name: film},
+			{Type: storage.EventVote, User: 1, Before: 2, After: 1},
+			{Type: storage.EventRetract, User: 1, Before: 1},
+			{Type: storage.EventReset},
+			{Type: storage.EventProfile, User: 1, Name: name, Username: username},
+			{Type: storage.EventRunoff},
+			{Type: storage.EventDisown, User: 1, Film: 1},
+			{Type: storage.EventType(text), User: 1},
+		}
+
+		h := &htmlBuilder{}
+		check("builder", h.bold(name).spoiler(place).link("https://t.me/"+username, film).String())
+
+		for _, lang := range []string{"ru", "en"} {
+			for key, tmpl := range c.tmpls[lang] {
+				var sb strings.Builder
+				if err := tmpl.Execute(&sb, data); err != nil {
+					t.Fatalf("%s %s: %v", lang, key, err)
+				}
+				check(lang+" "+key, sb.String())
+			}
+
+			loc := locale{catalog: c, lang: lang}
+			check(lang+" status", statusText(loc, stats, 1))
+			check(lang+" status full", statusFullText(loc, stats, sampleData.Rules))
+			check(lang+" rules", rulesText(loc, sampleData.Rules))
+			check(lang+" forgotten", forgottenText(loc, storage.Forgotten{Vote: true, Films: 1, RSVPs: 1, Sessions: 1, Events: 1}))
+			for _, e := range events {
+				check(lang+" audit "+string(e.Type), formatEvent(loc, e, users, films))
+			}
+			check(lang+" broadcast preview", broadcastPreview(loc, broadcast{Text: escape(text)}, 1, 1))
+			check(lang+" broadcast reply preview", broadcastPreview(loc, broadcast{FromChat: 1, MsgId: 1}, 1, 1))
+		}
+	})
+}
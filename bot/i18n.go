@@ -0,0 +1,256 @@
+package bot
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+	"vote/storage"
+	"vote/tgclient"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultLang = "ru"
+
+//go:embed locales/*.yaml
+var localesFS embed.FS
+
+// msgData is what message templates see, every message fills only the fields it needs.
+// Templates are html/template, so values are escaped, only HTML is inserted as is.
+// Keep the list in locales/ru.yaml up to date.
+type msgData struct {
+	// the reader or the user the message is about
+	User   storage.UserInfo
+	UserId int64
+	// only the name is known for films that are not in the list
+	Film      storage.FilmStat
+	Deadline  time.Time
+	Screening storage.Screening
+	Rules     storage.Rules
+	Report    broadcastReport
+	// when it happened or will happen
+	Time time.Time
+	// how long is left
+	Left time.Duration
+	// a number and what it is out of, e.g. voters of registered users
+	Count int
+	Total int
+	// a place in a top list
+	Rank  int
+	Text  string
+	List  []string
+	Emoji string
+	// parts rendered from other messages or with htmlBuilder
+	HTML template.HTML
+}
+
+var msgFuncs = template.FuncMap{
+	"join": strings.Join,
+	"link": userLink,
+	"hours": func(d time.Duration) int {
+		return int(d.Round(time.Minute).Hours())
+	},
+	"minutes": func(d time.Duration) int {
+		return int(d.Round(time.Minute).Minutes()) % 60
+	},
+}
+
+// sampleData fills every field, so executing a template with it finds misspelled fields
+var sampleData = msgData{
+	User:   storage.UserInfo{Name: "Name", LastName: "LastName", Username: "username", Vote: 1},
+	UserId: 1,
+	Film: storage.FilmStat{
+		Id: 1, Name: "Film", Votes: 1,
+		AddedBy: storage.UserInfo{Name: "Name"}, AddedById: 1, Trailer: "https://youtu.be/",
+	},
+	Deadline:  time.Now(),
+	Screening: storage.Screening{Id: 1, Place: "Place", Film: "Film"},
+	Rules:     storage.Rules{MembersOnly: true, Attended: 1, Of: 2, LoserBonus: 1, LoserStreak: 2},
+	Report:    broadcastReport{Total: 3, Delivered: 1, Blocked: 1},
+	Time:      time.Now(),
+	Left:      time.Hour,
+	Count:     1,
+	Total:     2,
+	Rank:      1,
+	Text:      "text",
+	List:      []string{"one", "two"},
+	Emoji:     "🔥",
+	HTML:      "<b>html</b>",
+}
+
+// catalog keeps message templates by language and key.
+// Messages missing in a language are taken from the default one.
+type catalog struct {
+	def   string
+	tmpls map[string]map[string]*template.Template
+}
+
+// loadCatalog reads <lang>.yaml files with nested keys, "vote: {counted: ...}" is "vote.counted".
+// Later sources override single messages of earlier ones or add languages.
+// Every key must be present in the default language and every template must render
+// sampleData, so a typo fails the start.
+func loadCatalog(def string, sources ...fs.FS) (*catalog, error) {
+	msgs := map[string]map[string]string{}
+	for _, fsys := range sources {
+		if err := readMessages(fsys, msgs); err != nil {
+			return nil, err
+		}
+	}
+
+	base, ok := msgs[def]
+	if !ok {
+		return nil, fmt.Errorf("no messages for the default language %q", def)
+	}
+
+	c := &catalog{def: def, tmpls: map[string]map[string]*template.Template{}}
+	for lang, texts := range msgs {
+		c.tmpls[lang] = map[string]*template.Template{}
+		for key, text := range texts {
+			if _, ok := base[key]; !ok {
+				return nil, fmt.Errorf("%s: unknown message %q", lang, key)
+			}
+			t, err := template.New(key).Funcs(msgFuncs).Parse(text)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", lang, err)
+			}
+			// misspelled fields are only found on execution
+			if err := t.Execute(io.Discard, sampleData); err != nil {
+				return nil, fmt.Errorf("%s: %w", lang, err)
+			}
+			c.tmpls[lang][key] = t
+		}
+		if n := len(base) - len(texts); n > 0 {
+			slog.Warn(fmt.Sprintf("%d messages are not translated to %s", n, lang))
+		}
+	}
+
+	return c, nil
+}
+
+func readMessages(fsys fs.FS, res map[string]map[string]string) error {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		lang, ok := strings.CutSuffix(e.Name(), ".yaml")
+		if !ok || e.IsDir() {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return err
+		}
+		var tree map[string]any
+		if err := yaml.Unmarshal(data, &tree); err != nil {
+			return fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		if res[lang] == nil {
+			res[lang] = map[string]string{}
+		}
+		if err := flattenMessages("", tree, res[lang]); err != nil {
+			return fmt.Errorf("%s: %w", e.Name(), err)
+		}
+	}
+	return nil
+}
+
+func flattenMessages(prefix string, tree map[string]any, res map[string]string) error {
+	for key, val := range tree {
+		switch v := val.(type) {
+		case string:
+			res[prefix+key] = v
+		case map[string]any:
+			if err := flattenMessages(prefix+key+".", v, res); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("message %s%s is not a string", prefix, key)
+		}
+	}
+	return nil
+}
+
+// langs returns supported languages
+func (c *catalog) langs() []string {
+	return slices.Sorted(maps.Keys(c.tmpls))
+}
+
+// match finds a supported language for a Telegram language code, "en-US" matches "en"
+func (c *catalog) match(code string) (string, bool) {
+	code = strings.ToLower(code)
+	if _, ok := c.tmpls[code]; ok {
+		return code, true
+	}
+	if i := strings.IndexByte(code, '-'); i > 0 {
+		if _, ok := c.tmpls[code[:i]]; ok {
+			return code[:i], true
+		}
+	}
+	return "", false
+}
+
+// render executes the message template, an unknown key is returned as is
+func (c *catalog) render(lang string, key string, data msgData) string {
+	t, ok := c.tmpls[lang][key]
+	if !ok {
+		t, ok = c.tmpls[c.def][key]
+	}
+	if !ok {
+		slog.Error("unknown message " + key)
+		return key
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		slog.Error(fmt.Sprintf("failed to render message %s: %s", key, err.Error()))
+	}
+	return buf.String()
+}
+
+// locale renders messages in one language
+type locale struct {
+	catalog *catalog
+	lang    string
+}
+
+// text renders a message that needs no data
+func (l locale) text(key string) string {
+	return l.catalog.render(l.lang, key, msgData{})
+}
+
+func (l locale) format(key string, data msgData) string {
+	return l.catalog.render(l.lang, key, data)
+}
+
+// locale picks the language of the user: chosen with /lang, then the Telegram client's, then the default
+func (b *Bot) locale(u tgclient.User) locale {
+	return b.userLocale(u.Id, u.LanguageCode)
+}
+
+// localeOf is for messages to users known by id only
+func (b *Bot) localeOf(userID int64) locale {
+	return b.userLocale(userID, "")
+}
+
+func (b *Bot) userLocale(userID int64, code string) locale {
+	usr := b.storage.GetUser(userID)
+	for _, c := range []string{usr.Language, code, usr.LanguageCode} {
+		if lang, ok := b.messages.match(c); ok {
+			return locale{catalog: b.messages, lang: lang}
+		}
+	}
+	return b.chatLocale()
+}
+
+// chatLocale is for messages everyone sees: dashboards and announcements in the main chat
+func (b *Bot) chatLocale() locale {
+	return locale{catalog: b.messages, lang: b.messages.def}
+}
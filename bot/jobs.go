@@ -0,0 +1,113 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"strings"
+	"time"
+	"vote/storage"
+)
+
+const (
+	jobNudge   = "nudge"
+	jobClosing = "closing"
+	jobDigest  = "digest"
+	// built in, not configurable
+	jobRunoff = "runoff"
+
+	digestPeriod = time.Hour * 24 * 7
+)
+
+// nudgeVoters DMs registered members who haven't voted yet
+func (b *Bot) nudgeVoters(ctx context.Context, now time.Time) {
+	deadline := b.storage.Deadline()
+	for id, info := range b.storage.Snapshot().Users {
+		if info.Vote != 0 || info.Inactive || info.Quit {
+			continue
+		}
+		loc := b.localeOf(id)
+		text := loc.text("jobs.nudge")
+		if !deadline.IsZero() && now.Before(deadline) {
+			text = loc.format("jobs.nudge_deadline", msgData{HTML: template.HTML(untilText(loc, deadline.Sub(now)))})
+		}
+		if err := b.sendVoteKeyboard(ctx, id, text); err != nil {
+			slog.Error(fmt.Sprintf("failed to nudge user %d: %s", id, err.Error()))
+		}
+	}
+}
+
+// announceClosing tells the main chat that voting closes soon
+func (b *Bot) announceClosing(ctx context.Context, now time.Time) {
+	deadline := b.storage.Deadline()
+	if deadline.IsZero() || !now.Before(deadline) {
+		return
+	}
+	loc := b.chatLocale()
+	text := loc.format("jobs.closing", msgData{HTML: template.HTML(untilText(loc, deadline.Sub(now)))}) + "\n\n" + statusText(loc, b.storage.Status(), 0)
+	if err := b.client.SendMessage(ctx, b.mainChatId, text); err != nil {
+		slog.Error("failed to announce closing: " + err.Error())
+	}
+}
+
+// digest posts the weekly summary to the main chat
+func (b *Bot) digest(ctx context.Context, now time.Time) {
+	stats := b.storage.Status()
+	snap := b.storage.Snapshot()
+
+	loc := b.chatLocale()
+	builder := strings.Builder{}
+	builder.WriteString(loc.text("digest.title") + "\n\n")
+	builder.WriteString(statusText(loc, stats, 0))
+
+	voters := 0
+	for i := range stats {
+		voters += stats[i].Ballots
+	}
+	builder.WriteString("\n" + loc.format("digest.voted", msgData{Count: voters, Total: len(snap.Users)}) + "\n")
+
+	var added []string
+	for _, info := range snap.Films {
+		if info.AddedAt != 0 && now.Sub(time.Unix(info.AddedAt, 0)) < digestPeriod {
+			added = append(added, info.Name)
+		}
+	}
+	if len(added) > 0 {
+		builder.WriteString(loc.format("digest.new", msgData{Count: len(added)}) + "\n")
+	}
+	if deadline := b.storage.Deadline(); !deadline.IsZero() && now.Before(deadline) {
+		builder.WriteString(loc.format("digest.deadline", msgData{Deadline: deadline}) + "\n")
+	}
+	for _, sc := range b.storage.Screenings() {
+		if start := time.Unix(sc.Time, 0); now.Before(start) {
+			builder.WriteString(loc.format("digest.screening", msgData{Screening: sc, Time: start}) + "\n")
+		}
+	}
+
+	if err := b.client.SendMessage(ctx, b.mainChatId, builder.String()); err != nil {
+		slog.Error("failed to post digest: " + err.Error())
+	}
+}
+
+// checkRunoff starts a runoff if there is a tie at the top when the deadline passes,
+// it runs once per deadline
+func (b *Bot) checkRunoff(ctx context.Context, now time.Time) {
+	if b.storage.TieBreak() == storage.TieRunoff {
+		b.refreshVoters(ctx, 0)
+		b.startRunoff(ctx, now)
+	}
+}
+
+// untilText formats a duration as "1 ч 30 мин"
+func untilText(loc locale, d time.Duration) string {
+	d = d.Round(time.Minute)
+	h, m := int(d.Hours()), int(d.Minutes())%60
+	switch {
+	case h > 0 && m > 0:
+		return loc.format("duration.hours_minutes", msgData{Left: d})
+	case h > 0:
+		return loc.format("duration.hours", msgData{Left: d})
+	}
+	return loc.format("duration.minutes", msgData{Left: d})
+}
@@ -0,0 +1,72 @@
+package bot
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const (
+	// how long a preview can be confirmed
+	pendingTTL = time.Hour
+
+	// ids are <nonce><counter>, the nonce is new on every start, so a button
+	// from before a restart never matches an item added after it
+	pendingCntBits = 32
+	pendingCntMask = 1<<pendingCntBits - 1
+)
+
+// pending keeps actions waiting for confirmation by an inline button
+type pending[T any] struct {
+	mu    sync.Mutex
+	items map[int64]pendingItem[T]
+	nonce int64
+	cnt   int64
+}
+
+type pendingItem[T any] struct {
+	val     T
+	expires time.Time
+}
+
+func newPending[T any]() *pending[T] {
+	return &pending[T]{
+		items: map[int64]pendingItem[T]{},
+		nonce: rand.Int64N(1<<30) + 1,
+	}
+}
+
+func (p *pending[T]) add(v T) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for id, item := range p.items {
+		if now.After(item.expires) {
+			delete(p.items, id)
+		}
+	}
+
+	p.cnt = (p.cnt + 1) & pendingCntMask
+	id := p.nonce<<pendingCntBits | p.cnt
+	p.items[id] = pendingItem[T]{val: v, expires: now.Add(pendingTTL)}
+	return id
+}
+
+// take removes the item, so it is handled only once. Items of another run
+// of the bot and expired ones are not found.
+func (p *pending[T]) take(id int64) (T, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var zero T
+	if id>>pendingCntBits != p.nonce {
+		return zero, false
+	}
+	item, ok := p.items[id]
+	delete(p.items, id)
+	if !ok || time.Now().After(item.expires) {
+		return zero, false
+	}
+	return item.val, true
+}
@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"strings"
+	"vote/storage"
+	"vote/tgclient"
+)
+
+const (
+	posterModeAlbum  = "album"
+	posterModePhotos = "photos"
+)
+
+func (b *Bot) card(ctx context.Context, msg *tgclient.Message, film string) {
+	if film == "" {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("card.usage")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	stat, ok := b.findFilm(film)
+	if !ok {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).format("common.not_found", msgData{Film: storage.FilmStat{Name: film}})); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	b.sendCard(ctx, msg, stat)
+}
+
+func (b *Bot) sendCard(ctx context.Context, msg *tgclient.Message, stat storage.FilmStat) {
+	text := cardText(b.locale(msg.From), stat)
+
+	var err error
+	if stat.Poster == "" {
+		err = b.client.Answer(ctx, msg, text)
+	} else {
+		_, err = b.client.SendPhoto(ctx, msg.Chat.Id, msg.ThreadId, tgclient.InputFile{FileId: stat.Poster}, text)
+	}
+	if err != nil {
+		slog.Error("Failed to send film card: " + err.Error())
+	}
+}
+
+// announcePosters sends posters of the candidates before the voting dashboard
+func (b *Bot) announcePosters(ctx context.Context, msg *tgclient.Message, stats []storage.FilmStat) {
+	switch b.posterMode {
+	case posterModePhotos:
+		for _, stat := range stats {
+			if stat.Poster != "" {
+				b.sendCard(ctx, msg, stat)
+			}
+		}
+
+	case posterModeAlbum:
+		var media []tgclient.InputMedia
+		for _, stat := range stats {
+			if stat.Poster != "" {
+				media = append(media, tgclient.InputMedia{
+					Type:      tgclient.MediaTypePhoto,
+					Media:     stat.Poster,
+					Caption:   b.locale(msg.From).format("card.caption", msgData{Film: stat}),
+					ParseMode: "HTML",
+				})
+			}
+		}
+		for len(media) > 0 {
+			n := min(len(media), tgclient.MaxMediaGroup)
+			// a media group needs at least two items
+			if len(media)-n == 1 {
+				n--
+			}
+			chunk := media[:n]
+			media = media[n:]
+
+			var err error
+			if len(chunk) == 1 {
+				_, err = b.client.SendPhoto(ctx, msg.Chat.Id, msg.ThreadId, tgclient.InputFile{FileId: chunk[0].Media}, chunk[0].Caption)
+			} else {
+				_, err = b.client.SendMediaGroup(ctx, msg.Chat.Id, msg.ThreadId, chunk)
+			}
+			if err != nil {
+				slog.Error("Failed to send posters: " + err.Error())
+			}
+		}
+	}
+}
+
+// admin command
+func (b *Bot) poster(ctx context.Context, msg *tgclient.Message, film string) {
+	if !b.isAdmin(msg.From.Id) {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("common.not_admin")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	if film == "" || msg.ReplyTo == nil || len(msg.ReplyTo.Photo) == 0 {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("poster.usage")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	// the last size is the largest one
+	photo := msg.ReplyTo.Photo[len(msg.ReplyTo.Photo)-1]
+	found, err := b.storage.SetPoster(film, photo.FileId)
+	if err != nil {
+		slog.Error("failed to set poster: " + err.Error())
+	}
+
+	text := b.locale(msg.From).format("common.not_found", msgData{Film: storage.FilmStat{Name: film}})
+	if found {
+		text = b.locale(msg.From).format("poster.saved", msgData{Film: storage.FilmStat{Name: film}})
+	}
+	if err := b.client.Answer(ctx, msg, text); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+// admin command
+func (b *Bot) trailer(ctx context.Context, msg *tgclient.Message, arg string) {
+	if !b.isAdmin(msg.From.Id) {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("common.not_admin")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	var film, link string
+	if i := strings.LastIndexByte(arg, ' '); i > 0 {
+		film, link = strings.TrimSpace(arg[:i]), arg[i+1:]
+	}
+	if u, err := url.Parse(link); film == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("trailer.usage")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	found, err := b.storage.SetTrailer(film, link)
+	if err != nil {
+		slog.Error("failed to set trailer: " + err.Error())
+	}
+
+	text := b.locale(msg.From).format("common.not_found", msgData{Film: storage.FilmStat{Name: film}})
+	if found {
+		text = b.locale(msg.From).format("trailer.saved", msgData{Film: storage.FilmStat{Name: film}})
+	}
+	if err := b.client.Answer(ctx, msg, text); err != nil {
+		slog.Error(err.Error())
+	}
+}
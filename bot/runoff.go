@@ -0,0 +1,51 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	"vote/storage"
+)
+
+func (b *Bot) startRunoff(ctx context.Context, now time.Time) {
+	deadline := now.Add(b.runoffTime)
+	films, voters, err := b.storage.StartRunoff(deadline)
+	if err != nil {
+		slog.Error("failed to start runoff: " + err.Error())
+		return
+	}
+	if len(films) == 0 {
+		return
+	}
+	slog.Info(fmt.Sprintf("runoff started between films %v", films))
+	b.refreshMonitors()
+
+	var tied []storage.FilmStat
+	for _, stat := range b.storage.Status() {
+		if slices.Contains(films, stat.Id) {
+			tied = append(tied, stat)
+		}
+	}
+	text := func(loc locale) string {
+		builder := strings.Builder{}
+		builder.WriteString(loc.text("runoff.title") + "\n")
+		for _, stat := range tied {
+			builder.WriteString(loc.format("runoff.film", msgData{Film: stat}) + "\n")
+		}
+		builder.WriteString(loc.format("runoff.until", msgData{Deadline: deadline}))
+		return builder.String()
+	}
+
+	if err := b.client.SendMessage(ctx, b.mainChatId, text(b.chatLocale())); err != nil {
+		slog.Error("failed to announce runoff: " + err.Error())
+	}
+	for _, id := range voters {
+		loc := b.localeOf(id)
+		if err := b.sendVoteKeyboard(ctx, id, text(loc)+"\n\n"+loc.text("runoff.revote")); err != nil {
+			slog.Error(fmt.Sprintf("failed to ask user %d to vote in runoff: %s", id, err.Error()))
+		}
+	}
+}
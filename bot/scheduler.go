@@ -0,0 +1,148 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+	"vote/config"
+	"vote/storage"
+)
+
+type job struct {
+	// persisted state key, empty for jobs that run on every tick
+	name string
+	// due reports whether the job should run given the state saved by its last run,
+	// and the state to save if it runs
+	due func(now time.Time, last int64) (bool, int64)
+	run func(ctx context.Context, now time.Time)
+}
+
+// scheduler runs jobs on a ticker by the storage clock. Job states are kept in storage,
+// so a job that was due while the bot was down runs once after the restart.
+type scheduler struct {
+	storage *storage.Storage
+	jobs    []job
+	// jobs that never ran are scheduled from here
+	start time.Time
+}
+
+func newScheduler(st *storage.Storage) *scheduler {
+	return &scheduler{
+		storage: st,
+		start:   st.Now(),
+	}
+}
+
+func (s *scheduler) add(j job) {
+	s.jobs = append(s.jobs, j)
+}
+
+func (s *scheduler) run(ctx context.Context, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(timeCheck)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.tick(ctx)
+		case <-stopCh:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// tick runs all due jobs, the state is saved before running so a failing job is not retried forever
+func (s *scheduler) tick(ctx context.Context) {
+	now := s.storage.Now()
+	for _, j := range s.jobs {
+		if j.name == "" {
+			j.run(ctx, now)
+			continue
+		}
+
+		ok, state := j.due(now, s.storage.JobState(j.name))
+		if !ok {
+			continue
+		}
+		if err := s.storage.SetJobState(j.name, state); err != nil {
+			slog.Error(fmt.Sprintf("failed to save job %s state: %s", j.name, err.Error()))
+			continue
+		}
+		slog.Info("Running job " + j.name)
+		j.run(ctx, now)
+	}
+}
+
+// cronDue runs a job at times matching the spec, the state is the last run time
+func (s *scheduler) cronDue(spec cronSpec) func(now time.Time, last int64) (bool, int64) {
+	return func(now time.Time, last int64) (bool, int64) {
+		from := s.start
+		if last != 0 {
+			from = time.Unix(last, 0)
+		}
+		next := spec.Next(from)
+		return !next.IsZero() && !now.Before(next), now.Unix()
+	}
+}
+
+// deadlineDue runs a job once per deadline, before it by the given duration.
+// The state is the deadline the job ran for.
+func (s *scheduler) deadlineDue(before time.Duration) func(now time.Time, last int64) (bool, int64) {
+	return func(now time.Time, last int64) (bool, int64) {
+		deadline := s.storage.Deadline()
+		if deadline.IsZero() || deadline.Unix() == last {
+			return false, last
+		}
+		return !now.Before(deadline.Add(-before)) && now.Before(deadline), deadline.Unix()
+	}
+}
+
+// afterDeadlineDue runs a job once per deadline, when it has passed.
+// The state is the deadline the job ran for.
+func (s *scheduler) afterDeadlineDue() func(now time.Time, last int64) (bool, int64) {
+	return func(now time.Time, last int64) (bool, int64) {
+		deadline := s.storage.Deadline()
+		if deadline.IsZero() || deadline.Unix() == last {
+			return false, last
+		}
+		return !now.Before(deadline), deadline.Unix()
+	}
+}
+
+// addJobs adds jobs from the config
+func (b *Bot) addJobs(jobs []config.Job) error {
+	kinds := map[string]func(ctx context.Context, now time.Time){
+		jobNudge:   b.nudgeVoters,
+		jobClosing: b.announceClosing,
+		jobDigest:  b.digest,
+	}
+
+	for _, cfg := range jobs {
+		run, ok := kinds[cfg.Job]
+		if !ok {
+			return fmt.Errorf("unknown job %q", cfg.Job)
+		}
+
+		j := job{run: run}
+		switch {
+		case cfg.Cron != "" && cfg.BeforeDeadline == 0:
+			spec, err := parseCron(cfg.Cron)
+			if err != nil {
+				return fmt.Errorf("job %s: %w", cfg.Job, err)
+			}
+			j.name = cfg.Job + " " + cfg.Cron
+			j.due = b.scheduler.cronDue(spec)
+		case cfg.Cron == "" && cfg.BeforeDeadline > 0:
+			j.name = fmt.Sprintf("%s -%s", cfg.Job, cfg.BeforeDeadline)
+			j.due = b.scheduler.deadlineDue(cfg.BeforeDeadline)
+		default:
+			return fmt.Errorf("job %s: set either cron or before_deadline", cfg.Job)
+		}
+		b.scheduler.add(j)
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+	"vote/storage"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+// newClockStorage opens an empty storage run by the clock
+func newClockStorage(t *testing.T, dir string, clock *fakeClock) *storage.Storage {
+	t.Helper()
+	st, err := storage.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.SetClock(clock)
+	return st
+}
+
+// date is in the local time zone, as job states are restored in it
+func date(day int, hour int, minute int) time.Time {
+	return time.Date(2024, time.January, day, hour, minute, 0, 0, time.Local)
+}
+
+// runs counts runs of a job
+type runs int
+
+func (r *runs) run(context.Context, time.Time) { *r++ }
+
+// tickAt moves the clock and returns how many times the job ran
+func tickAt(s *scheduler, clock *fakeClock, r *runs, now time.Time) int {
+	before := *r
+	clock.now = now
+	s.tick(context.Background())
+	return int(*r - before)
+}
+
+func TestCronDue(t *testing.T) {
+	dir := t.TempDir()
+	spec, err := parseCron("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r runs
+	start := func(now time.Time) (*scheduler, *fakeClock) {
+		clock := &fakeClock{now: now}
+		s := newScheduler(newClockStorage(t, dir, clock))
+		s.add(job{name: "test", due: s.cronDue(spec), run: r.run})
+		return s, clock
+	}
+
+	s, clock := start(date(1, 8, 0))
+	steps := []struct {
+		now  time.Time
+		want int
+	}{
+		{date(1, 8, 0), 0},
+		{date(1, 8, 59), 0},
+		{date(1, 9, 0), 1},
+		{date(1, 9, 1), 0},
+		{date(1, 23, 0), 0},
+		{date(2, 9, 0), 1},
+	}
+	for _, step := range steps {
+		if got := tickAt(s, clock, &r, step.now); got != step.want {
+			t.Fatalf("at %v the job ran %d times, want %d", step.now, got, step.want)
+		}
+	}
+
+	// the bot was down at 9:00 on the 3rd and the 4th, the job catches up once
+	s, clock = start(date(4, 12, 0))
+	if got := tickAt(s, clock, &r, date(4, 12, 0)); got != 1 {
+		t.Fatalf("after the downtime the job ran %d times, want 1", got)
+	}
+	if got := tickAt(s, clock, &r, date(4, 12, 1)); got != 0 {
+		t.Fatalf("after catching up the job ran %d times, want 0", got)
+	}
+	if got := tickAt(s, clock, &r, date(5, 9, 0)); got != 1 {
+		t.Fatalf("the next day the job ran %d times, want 1", got)
+	}
+}
+
+func TestCronDueFirstStart(t *testing.T) {
+	spec, err := parseCron("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r runs
+	clock := &fakeClock{now: date(1, 12, 0)}
+	s := newScheduler(newClockStorage(t, t.TempDir(), clock))
+	s.add(job{name: "test", due: s.cronDue(spec), run: r.run})
+
+	// a new job doesn't run for times before the bot started
+	if got := tickAt(s, clock, &r, date(1, 12, 0)); got != 0 {
+		t.Fatalf("the new job ran %d times, want 0", got)
+	}
+	if got := tickAt(s, clock, &r, date(2, 9, 0)); got != 1 {
+		t.Fatalf("the job ran %d times, want 1", got)
+	}
+}
+
+func TestDeadlineDue(t *testing.T) {
+	dir := t.TempDir()
+	var r runs
+	start := func() (*scheduler, *fakeClock, *storage.Storage) {
+		clock := &fakeClock{}
+		st := newClockStorage(t, dir, clock)
+		s := newScheduler(st)
+		s.add(job{name: "test", due: s.deadlineDue(2 * time.Hour), run: r.run})
+		return s, clock, st
+	}
+
+	s, clock, st := start()
+	if got := tickAt(s, clock, &r, date(5, 17, 0)); got != 0 {
+		t.Fatalf("without a deadline the job ran %d times", got)
+	}
+	if err := st.SetDeadline(date(5, 19, 0)); err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		now  time.Time
+		want int
+	}{
+		{date(5, 16, 59), 0},
+		{date(5, 17, 0), 1},
+		{date(5, 17, 1), 0},
+		{date(5, 18, 59), 0},
+		{date(5, 19, 0), 0},
+	}
+	for _, step := range steps {
+		if got := tickAt(s, clock, &r, step.now); got != step.want {
+			t.Fatalf("at %v the job ran %d times, want %d", step.now, got, step.want)
+		}
+	}
+
+	// the run is remembered over a restart
+	s, clock, st = start()
+	if got := tickAt(s, clock, &r, date(5, 18, 0)); got != 0 {
+		t.Fatalf("after the restart the job ran %d times, want 0", got)
+	}
+
+	// a new deadline is announced again
+	if err := st.SetDeadline(date(6, 19, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if got := tickAt(s, clock, &r, date(6, 18, 0)); got != 1 {
+		t.Fatalf("for the new deadline the job ran %d times, want 1", got)
+	}
+
+	// the bot was down the whole window before the deadline
+	if err := st.SetDeadline(date(7, 19, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if got := tickAt(s, clock, &r, date(7, 20, 0)); got != 0 {
+		t.Fatalf("after the deadline the job ran %d times, want 0", got)
+	}
+}
+
+func TestAfterDeadlineDue(t *testing.T) {
+	var r runs
+	clock := &fakeClock{}
+	st := newClockStorage(t, t.TempDir(), clock)
+	s := newScheduler(st)
+	s.add(job{name: "test", due: s.afterDeadlineDue(), run: r.run})
+
+	if err := st.SetDeadline(date(5, 19, 0)); err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		now  time.Time
+		want int
+	}{
+		{date(5, 18, 59), 0},
+		{date(5, 19, 0), 1},
+		{date(5, 19, 1), 0},
+		{date(6, 19, 0), 0},
+	}
+	for _, step := range steps {
+		if got := tickAt(s, clock, &r, step.now); got != step.want {
+			t.Fatalf("at %v the job ran %d times, want %d", step.now, got, step.want)
+		}
+	}
+}
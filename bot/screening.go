@@ -0,0 +1,250 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+	"vote/storage"
+	"vote/tgclient"
+)
+
+var rsvpActions = map[action]storage.RSVP{
+	actGoing:    storage.RSVPGoing,
+	actMaybe:    storage.RSVPMaybe,
+	actNotGoing: storage.RSVPNo,
+}
+
+// admin command
+func (b *Bot) screening(ctx context.Context, msg *tgclient.Message, arg string) {
+	if !b.isAdmin(msg.From.Id) {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("common.not_admin")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	sc, ok := parseScreening(arg)
+	if !ok {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("screening.usage")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+	if sc.Film == "" {
+		if stats := b.storage.Status(); len(stats) > 0 && stats[0].Votes > 0 {
+			sc.Film = stats[0].Name
+		}
+	}
+
+	id, err := b.storage.AddScreening(sc)
+	if err != nil {
+		slog.Error("failed to add screening: " + err.Error())
+		return
+	}
+	sc, _ = b.storage.Screening(id)
+
+	m, err := b.client.SendInlineKeyboard(ctx, b.mainChatId, b.screeningText(sc), b.rsvpKeyboard(id))
+	if err != nil {
+		slog.Error("failed to post screening: " + err.Error())
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("screening.post_failed")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+	if err := b.storage.SetScreeningMessage(id, m.Chat.Id, m.Id); err != nil {
+		slog.Error("failed to save screening message: " + err.Error())
+	}
+
+	if msg.Chat.Id != b.mainChatId {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).format("screening.planned", msgData{Screening: storage.Screening{Id: id}})); err != nil {
+			slog.Error(err.Error())
+		}
+	}
+}
+
+// parseScreening parses "2025-03-01 19:00 | place | film", the film is optional
+func parseScreening(arg string) (storage.Screening, bool) {
+	parts := strings.Split(arg, "|")
+	if len(parts) < 2 || len(parts) > 3 {
+		return storage.Screening{}, false
+	}
+	t, err := time.ParseInLocation(deadlineLayout, strings.TrimSpace(parts[0]), time.Local)
+	if err != nil {
+		return storage.Screening{}, false
+	}
+	sc := storage.Screening{
+		Time:  t.Unix(),
+		Place: strings.TrimSpace(parts[1]),
+	}
+	if len(parts) == 3 {
+		sc.Film = strings.TrimSpace(parts[2])
+	}
+	return sc, sc.Place != ""
+}
+
+// screeningText renders the invitation, it is posted to the main chat so everyone reads the same language
+func (b *Bot) screeningText(sc storage.Screening) string {
+	loc := b.chatLocale()
+	users := b.storage.Snapshot().Users
+	responded := func(ids []int64) msgData {
+		res := make([]string, len(ids))
+		for i, id := range ids {
+			res[i] = users[id].Name
+		}
+		return msgData{Count: len(ids), List: res}
+	}
+
+	builder := strings.Builder{}
+	builder.WriteString(loc.format("screening.title", msgData{Screening: sc, Time: time.Unix(sc.Time, 0)}) + "\n\n")
+
+	builder.WriteString(loc.format("screening.going", responded(sc.Responded(storage.RSVPGoing))) + "\n")
+	builder.WriteString(loc.format("screening.maybe", responded(sc.Responded(storage.RSVPMaybe))) + "\n")
+	builder.WriteString(loc.format("screening.not_going", responded(sc.Responded(storage.RSVPNo))))
+	if sc.Attended != nil {
+		builder.WriteString("\n\n" + loc.format("screening.attended", responded(sc.Attended)))
+	}
+
+	return builder.String()
+}
+
+func (b *Bot) rsvpKeyboard(id int) tgclient.InlineKeyboardMarkup {
+	loc := b.chatLocale()
+	// RSVP outlives voting sessions, so the session is not used
+	button := func(key string, act action) tgclient.InlineKeyboardButton {
+		return tgclient.InlineKeyboardButton{Text: loc.text(key), Data: b.codec.Encode(callbackData{Action: act, Arg: int64(id)})}
+	}
+	return tgclient.InlineKeyboardMarkup{Keyboard: [][]tgclient.InlineKeyboardButton{{
+		button("screening.button_going", actGoing),
+		button("screening.button_maybe", actMaybe),
+		button("screening.button_not_going", actNotGoing),
+	}}}
+}
+
+func (b *Bot) processRSVP(ctx context.Context, update *tgclient.Update, data callbackData) {
+	id := int(data.Arg)
+	b.ensureUser(update.Callback.From)
+	err := b.storage.SetRSVP(id, update.Callback.From.Id, rsvpActions[data.Action])
+	loc := b.locale(update.Callback.From)
+	switch {
+	case err == nil:
+		b.answerCallback(ctx, update, loc.text("screening.saved"), false)
+	case errors.Is(err, storage.ErrUnknownUser):
+		b.answerCallback(ctx, update, loc.text("common.start_first"), true)
+		return
+	case errors.Is(err, storage.ErrUnknownScreening):
+		b.answerCallback(ctx, update, loc.text("screening.gone"), true)
+		return
+	default:
+		slog.Error("failed to save RSVP: " + err.Error())
+		b.answerCallback(ctx, update, loc.text("common.failed"), false)
+		return
+	}
+
+	b.updateScreeningMessage(ctx, id)
+}
+
+func (b *Bot) updateScreeningMessage(ctx context.Context, id int) {
+	sc, ok := b.storage.Screening(id)
+	if !ok || sc.MsgId == 0 {
+		return
+	}
+	if err := b.client.EditMessage(ctx, sc.ChatId, sc.MsgId, b.screeningText(sc), b.rsvpKeyboard(id)); err != nil && !tgclient.IsNotModified(err) {
+		slog.Error("failed to update screening message: " + err.Error())
+	}
+}
+
+// remindScreenings DMs users who are going to screenings starting within the reminder time
+func (b *Bot) remindScreenings(ctx context.Context, now time.Time) {
+	for _, sc := range b.storage.Screenings() {
+		start := time.Unix(sc.Time, 0)
+		if sc.Reminded || now.After(start) || start.Sub(now) > b.screeningRemind {
+			continue
+		}
+		if err := b.storage.SetReminded(sc.Id); err != nil {
+			slog.Error("failed to mark screening reminded: " + err.Error())
+			continue
+		}
+
+		for _, id := range sc.Responded(storage.RSVPGoing) {
+			text := b.localeOf(id).format("screening.reminder", msgData{Screening: sc, Time: start})
+			if err := b.client.SendMessage(ctx, id, text); err != nil {
+				slog.Error(fmt.Sprintf("failed to remind user %d: %s", id, err.Error()))
+			}
+		}
+	}
+}
+
+// admin command
+func (b *Bot) attended(ctx context.Context, msg *tgclient.Message, arg string) {
+	if !b.isAdmin(msg.From.Id) {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("common.not_admin")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	fields := strings.Fields(arg)
+	var id int
+	var err error
+	if len(fields) > 0 {
+		id, err = strconv.Atoi(fields[0])
+	}
+	sc, ok := b.storage.Screening(id)
+	if len(fields) == 0 || err != nil || !ok {
+		if err := b.client.Answer(ctx, msg, b.locale(msg.From).text("attended.usage")); err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	// without names everyone who was going came
+	users := sc.Responded(storage.RSVPGoing)
+	if len(fields) > 1 {
+		users = users[:0]
+		var unknown []string
+		snap := b.storage.Snapshot()
+		for _, f := range fields[1:] {
+			if userID, ok := findUser(snap.Users, f); ok {
+				users = append(users, userID)
+			} else {
+				unknown = append(unknown, f)
+			}
+		}
+		if len(unknown) > 0 {
+			if err := b.client.Answer(ctx, msg, b.locale(msg.From).format("attended.unknown", msgData{List: unknown})); err != nil {
+				slog.Error(err.Error())
+			}
+			return
+		}
+	}
+
+	if err := b.storage.SetAttended(id, users); err != nil {
+		slog.Error("failed to save attendance: " + err.Error())
+		return
+	}
+	b.updateScreeningMessage(ctx, id)
+	if err := b.client.Answer(ctx, msg, b.locale(msg.From).format("attended.done", msgData{Screening: sc, Count: len(users)})); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+// findUser resolves @username or id
+func findUser(users map[int64]storage.UserInfo, s string) (int64, bool) {
+	if id, err := strconv.ParseInt(s, 10, 64); err == nil {
+		_, ok := users[id]
+		return id, ok
+	}
+	if !strings.HasPrefix(s, "@") {
+		return 0, false
+	}
+	for id, info := range users {
+		if strings.EqualFold(info.Username, s[1:]) {
+			return id, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,131 @@
+package bot
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+	"vote/storage"
+	"vote/tgclient"
+)
+
+const statsTop = 3
+
+type leader struct {
+	user  int64
+	count int
+}
+
+func (b *Bot) stats(ctx context.Context, msg *tgclient.Message) {
+	text := statsText(b.locale(msg.From), b.storage.Sessions(), b.storage.Screenings(), b.storage.Snapshot(), msg.From.Id)
+	if err := b.client.Answer(ctx, msg, text); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+func statsText(loc locale, sessions []storage.SessionRecord, screenings []storage.Screening, snap storage.Snapshot, userID int64) string {
+	wins := map[int64]int{}
+	turnout := map[int64]int{}
+	totalVoters := 0
+	for _, rec := range sessions {
+		for _, w := range rec.Winners {
+			wins[w.AddedBy]++
+		}
+		for _, id := range rec.Voters {
+			turnout[id]++
+		}
+		totalVoters += len(rec.Voters)
+	}
+	attendance := map[int64]int{}
+	held := 0
+	for _, sc := range screenings {
+		if sc.Attended == nil {
+			continue
+		}
+		held++
+		for _, id := range sc.Attended {
+			attendance[id]++
+		}
+	}
+
+	user := func(id int64) storage.UserInfo {
+		if u, ok := snap.Users[id]; ok {
+			return u
+		}
+		return storage.UserInfo{Name: "???"}
+	}
+
+	builder := strings.Builder{}
+	builder.WriteString(loc.text("stats.title") + "\n")
+	builder.WriteString(loc.format("stats.sessions", msgData{Count: len(sessions)}))
+	if len(sessions) > 0 {
+		builder.WriteString(loc.format("stats.turnout", msgData{Text: fmt.Sprintf("%.1f", float64(totalVoters)/float64(len(sessions)))}))
+	}
+	builder.WriteString("\n")
+
+	if top := leaders(wins); len(top) > 0 {
+		builder.WriteString("\n" + loc.text("stats.proposers") + "\n")
+		for i, l := range top {
+			builder.WriteString(loc.format("stats.proposer", msgData{Rank: i + 1, User: user(l.user), Count: l.count}) + "\n")
+		}
+	}
+	if top := leaders(turnout); len(top) > 0 {
+		builder.WriteString("\n" + loc.text("stats.voters") + "\n")
+		for i, l := range top {
+			builder.WriteString(loc.format("stats.voter", msgData{Rank: i + 1, User: user(l.user), Count: l.count}) + "\n")
+		}
+	}
+
+	if top := leaders(attendance); len(top) > 0 {
+		builder.WriteString("\n" + loc.text("stats.attendees") + "\n")
+		for i, l := range top {
+			builder.WriteString(loc.format("stats.attendee", msgData{Rank: i + 1, User: user(l.user), Count: l.count}) + "\n")
+		}
+	}
+
+	// ids grow monotonically, so the smallest one has been waiting the longest
+	if ids := slices.Sorted(maps.Keys(snap.Films)); len(ids) > 0 {
+		film := snap.Films[ids[0]]
+		builder.WriteString("\n" + loc.format("stats.waiting", msgData{Film: storage.FilmStat{Name: film.Name}}))
+		if film.AddedAt != 0 {
+			builder.WriteString(loc.format("stats.waiting_since", msgData{Time: time.Unix(film.AddedAt, 0)}))
+		}
+		builder.WriteString("\n")
+	}
+
+	proposed := 0
+	for _, f := range snap.Films {
+		if f.Added == userID {
+			proposed++
+		}
+	}
+	builder.WriteString("\n" + loc.format("stats.personal", msgData{Count: wins[userID]}))
+	builder.WriteString(loc.format("stats.personal_sessions", msgData{Count: turnout[userID], Total: len(sessions)}))
+	builder.WriteString(loc.format("stats.personal_films", msgData{Count: proposed}))
+	if held > 0 {
+		builder.WriteString(loc.format("stats.personal_screenings", msgData{Count: attendance[userID], Total: held}))
+	}
+	if vote, ok := snap.Films[snap.Users[userID].Vote]; ok {
+		builder.WriteString(loc.format("stats.personal_vote", msgData{Film: storage.FilmStat{Name: vote.Name}}))
+	}
+
+	return builder.String()
+}
+
+// leaders returns top users by count, ties are ordered by user id
+func leaders(counts map[int64]int) []leader {
+	res := make([]leader, 0, len(counts))
+	for user, count := range counts {
+		if user != 0 && count > 0 {
+			res = append(res, leader{user: user, count: count})
+		}
+	}
+	slices.SortFunc(res, func(a, b leader) int {
+		return cmp.Or(b.count-a.count, cmp.Compare(a.user, b.user))
+	})
+	return res[:min(len(res), statsTop)]
+}
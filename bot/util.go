@@ -1,38 +1,66 @@
 package bot
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 	"vote/storage"
+	"vote/tgclient"
 )
 
 const (
-	msgHelp = `<b>Я бот.</b>
-
-/status - посмотреть список фильмов и голосов
-/vote - проголосовать за фильм (в лс)
-/add Борат 2 - добавить фильм в список
-/status_full - посмотреть голоса
-
-/start - начало работы (должна быть отправлена хотябы раз!)
-/help - помощь`
-	msgHelpAdmin = msgHelp + `
-
-<b>Админские команды</b> 😈:
-/remove Борат 2 - удалить фильм из списка
-/monitor - обновляющийсяя в реальном времени status (работает только последнее сообщение)
-/reset - сбрасывает ВСЕ голоса`
-	msgAddNoFilm = "Invalid film name 🤡\n<span class=\"tg-spoiler\">Usage: /add Зелёный слоник 2</span>"
-	msgAddedTmpl = "\"%s\" добавлен в список 📋✍️"
+	monitorDebounce = time.Second * 2
+
+	deadlineLayout = "2006-01-02 15:04"
+
+	defaultRunoffTime      = time.Hour
+	defaultScreeningRemind = time.Hour * 2
+	timeCheck              = time.Second * 30
 )
 
+func profile(u tgclient.User) storage.Profile {
+	return storage.Profile{
+		Name:         u.Name,
+		LastName:     u.LastName,
+		Username:     u.Username,
+		LanguageCode: u.LanguageCode,
+	}
+}
+
+// refreshProfile keeps names of registered users up to date
+func (b *Bot) refreshProfile(u tgclient.User) {
+	if u.Id == 0 {
+		return
+	}
+	if err := b.storage.UpdateProfile(u.Id, profile(u)); err != nil && !errors.Is(err, storage.ErrUnknownUser) {
+		slog.Error("failed to update profile: " + err.Error())
+	}
+}
+
+// userLink links to the user's profile, by id if there is no username
+func userLink(userID int64, u storage.UserInfo) template.HTML {
+	href := fmt.Sprintf("tg://user?id=%d", userID)
+	if u.Username != "" {
+		href = "https://t.me/" + u.Username
+	}
+	h := htmlBuilder{}
+	return template.HTML(h.link(href, u.FullName()).String())
+}
+
 func (b *Bot) isAdmin(userID int64) bool {
 	return slices.Contains(b.admins, userID)
 }
 
-func (b *Bot) loadAdmins() error {
-	admins, err := b.client.ChatAdmins(b.mainChatId)
+func (b *Bot) loadAdmins(ctx context.Context) error {
+	admins, err := b.client.ChatAdmins(ctx, b.mainChatId)
 	if err != nil {
 		return err
 	}
@@ -55,44 +83,142 @@ func (b *Bot) loadAdmins() error {
 	return nil
 }
 
-func statusText(stats []storage.FilmStat, vote int) string {
+// builtinCatalog has the embedded messages, for tools without a config
+var builtinCatalog = sync.OnceValue(func() *catalog {
+	locales, err := fs.Sub(localesFS, "locales")
+	if err != nil {
+		panic(err)
+	}
+	messages, err := loadCatalog(defaultLang, locales)
+	if err != nil {
+		panic(err)
+	}
+	return messages
+})
+
+// StatusText renders the film list as /status does for a reader with the given language,
+// vote marks the reader's choice. An unknown language falls back to the default one.
+func StatusText(lang string, stats []storage.FilmStat, vote int) string {
+	loc := locale{catalog: builtinCatalog(), lang: defaultLang}
+	if l, ok := loc.catalog.match(lang); ok {
+		loc.lang = l
+	}
+	return statusText(loc, stats, vote)
+}
+
+func statusText(loc locale, stats []storage.FilmStat, vote int) string {
 	if len(stats) == 0 {
-		return "Фильмов пока нет 💀"
+		return loc.text("status.empty")
 	}
 
 	first, second := getPositions(stats)
 	builder := strings.Builder{}
 	for i := range stats {
-		emoj := ""
-		if stats[i].Id == vote {
-			emoj = " 💋"
-		}
-		builder.WriteString(fmt.Sprintf(
-			"%s<b>%s</b>: %d%s\n",
-			getEmoj(i, first, second), stats[i].Name, stats[i].Votes, emoj,
-		))
+		builder.WriteString(loc.format("status.line", msgData{
+			Film:  stats[i],
+			User:  storage.UserInfo{Vote: vote},
+			Emoji: getEmoj(i, first, second),
+		}) + "\n")
 	}
 
 	return builder.String()
 }
 
-func getPositions(stats []storage.FilmStat) (first, second int) {
-	max1, max2 := stats[0].Votes, 0
+func (b *Bot) dashboardText(stats []storage.FilmStat, updated time.Time) string {
+	loc := b.chatLocale()
+	builder := strings.Builder{}
+	if len(b.storage.Runoff()) > 0 {
+		builder.WriteString(loc.text("dashboard.runoff") + "\n\n")
+	} else {
+		builder.WriteString(loc.text("dashboard.title") + "\n\n")
+	}
+	builder.WriteString(statusText(loc, stats, 0))
+
+	voters := 0
 	for i := range stats {
-		cur := stats[i].Votes
-		if cur == max1 {
-			first++
-		} else if cur == max2 {
-			second++
-		} else if cur > max2 {
-			if max2 == 0 {
-				max2 = cur
-				second++
-			} else {
-				break
-			}
+		voters += stats[i].Ballots
+	}
+	builder.WriteString("\n" + loc.format("dashboard.voted", msgData{Count: voters}) + "\n")
+	if deadline := b.storage.Deadline(); !deadline.IsZero() {
+		builder.WriteString(loc.format("dashboard.deadline", msgData{Deadline: deadline}) + "\n")
+	}
+	if b.storage.TieBreak() == storage.TieRandom {
+		builder.WriteString(loc.format("dashboard.seed", msgData{Text: strconv.FormatUint(b.storage.Seed(), 10)}) + "\n")
+	}
+	builder.WriteString(loc.format("dashboard.updated", msgData{Time: updated}))
+
+	return builder.String()
+}
+
+func (b *Bot) voteKeyboard(stats []storage.FilmStat) tgclient.InlineKeyboardMarkup {
+	session := b.storage.Session()
+
+	// during a runoff only the tied films can be chosen
+	if runoff := b.storage.Runoff(); len(runoff) > 0 {
+		stats = slices.DeleteFunc(slices.Clone(stats), func(stat storage.FilmStat) bool {
+			return !slices.Contains(runoff, stat.Id)
+		})
+	}
+
+	n := len(stats)
+	keyboard := tgclient.InlineKeyboardMarkup{
+		Keyboard: make([][]tgclient.InlineKeyboardButton, n+1),
+	}
+	for i := range n {
+		keyboard.Keyboard[i] = []tgclient.InlineKeyboardButton{{
+			Text: stats[i].Name,
+			Data: b.codec.Encode(callbackData{Action: actVote, Session: session, Arg: int64(stats[i].Id)}),
+		}}
+	}
+	keyboard.Keyboard[n] = []tgclient.InlineKeyboardButton{{
+		Text: "❌",
+		Data: b.codec.Encode(callbackData{Action: actRetract, Session: session}),
+	}}
+
+	return keyboard
+}
+
+func cardText(loc locale, stat storage.FilmStat) string {
+	data := msgData{Film: stat}
+	builder := strings.Builder{}
+	builder.WriteString(loc.format("card.title", data) + "\n")
+	if stat.AddedBy.Name != "" {
+		builder.WriteString(loc.format("card.added_by", data) + "\n")
+	}
+	builder.WriteString(loc.format("card.votes", data))
+	if stat.Trailer != "" {
+		builder.WriteString("\n" + loc.format("card.trailer", data))
+	}
+	return builder.String()
+}
+
+func (b *Bot) findFilm(name string) (storage.FilmStat, bool) {
+	for _, stat := range b.storage.StatusFull() {
+		if stat.Name == name {
+			return stat, true
 		}
 	}
+	return storage.FilmStat{}, false
+}
+
+// getPositions counts films sharing the first and the second place.
+// A leader chosen by the tie break takes the first place alone.
+func getPositions(stats []storage.FilmStat) (first, second int) {
+	group := func(from int) int {
+		n := 0
+		for i := from; i < len(stats) && stats[i].Votes == stats[from].Votes; i++ {
+			n++
+		}
+		return n
+	}
+
+	first = group(0)
+	if stats[0].Leader {
+		first = 1
+	}
+	if first < len(stats) && stats[first].Votes > 0 {
+		second = group(first)
+	}
 	return
 }
 
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
@@ -26,12 +27,13 @@ func main() {
 		return
 	}
 
-	tgbot.Start()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	tgbot.Start(ctx)
 
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	select {
-	case <-sigCh:
+	case <-ctx.Done():
 		slog.Warn("Shutdown signal received")
 		tgbot.Stop()
 		<-tgbot.Wait()
@@ -0,0 +1,275 @@
+// votectl inspects and repairs bot storage without Telegram.
+// Commands that only read open the storage read-only and can run next to the bot,
+// stop the bot before running commands that modify data.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"vote/bot"
+	"vote/storage"
+)
+
+const usage = `Usage: votectl [-data dir] [-lang code] <command> [args]
+
+Commands:
+  users                  list registered users
+  films                  list films
+  votes                  list films with voters
+  tally                  print the tally as /status does to a reader with -lang
+  remove <film>          remove a film
+  rename <film> <new>    rename a film
+  reset                  reset all votes
+  validate [-fix]        check referential integrity, -fix repairs found problems
+  events [n]             print the last n events of the log (all by default)
+  replay [-write [-force]]
+                         compare the state with the one replayed from the event log,
+                         -write replaces users, films and counters with the replayed state,
+                         -force merges it over the state if the log doesn't start with a snapshot
+`
+
+func main() {
+	dataPath := flag.String("data", os.Getenv("VOTE_DATA_PATH"), "path to the storage directory")
+	lang := flag.String("lang", "", "language of the tally, the built-in default if empty")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *dataPath == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	args := flag.Args()
+	fix := args[0] == "validate" && len(args) > 1 && args[1] == "-fix"
+	write := args[0] == "replay" && len(args) > 1 && args[1] == "-write"
+
+	// the bot may be running on the data, only commands that change it open it for writing
+	open := storage.NewReadOnly
+	switch {
+	case args[0] == "remove", args[0] == "rename", args[0] == "reset", fix, write:
+		open = storage.New
+	}
+	st, err := open(*dataPath)
+	if err != nil {
+		fail(err)
+	}
+
+	switch cmd := args[0]; cmd {
+	case "users":
+		listUsers(st)
+	case "films":
+		listFilms(st)
+	case "votes":
+		listVotes(st)
+	case "tally":
+		text := bot.StatusText(*lang, st.Status(), 0)
+		fmt.Println(strings.TrimSuffix(text, "\n"))
+	case "remove":
+		needArgs(args, 2)
+		found, affected, err := st.RemoveFilm(0, args[1])
+		if err != nil {
+			fail(err)
+		}
+		report(found, fmt.Sprintf("%s removed, %d votes cleared", args[1], len(affected)), args[1]+" wasn't found")
+	case "rename":
+		needArgs(args, 3)
+		found, err := st.RenameFilm(args[1], args[2])
+		if err != nil {
+			fail(err)
+		}
+		report(found, args[1]+" renamed to "+args[2], args[1]+" wasn't found")
+	case "reset":
+		if err := st.ResetVotes(0); err != nil {
+			fail(err)
+		}
+		fmt.Println("votes reset")
+	case "validate":
+		validate(st, fix)
+	case "events":
+		n := 0
+		if len(args) > 1 {
+			if n, err = strconv.Atoi(args[1]); err != nil {
+				fail(err)
+			}
+		}
+		listEvents(st, n)
+	case "replay":
+		force := write && len(args) > 2 && args[2] == "-force"
+		replay(st, filepath.Join(*dataPath, "events.jsonl"), write, force)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", cmd)
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func listUsers(st *storage.Storage) {
+	snap := st.Snapshot()
+	ids := slices.Sorted(maps.Keys(snap.Users))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tUSERNAME\tVOTE\tINACTIVE\tQUIT\tLAST SEEN")
+	for _, id := range ids {
+		u := snap.Users[id]
+		seen := "-"
+		if u.LastSeen != 0 {
+			seen = time.Unix(u.LastSeen, 0).Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%t\t%t\t%s\n", id, u.FullName(), u.Username, u.Vote, u.Inactive, u.Quit, seen)
+	}
+	w.Flush()
+}
+
+func listFilms(st *storage.Storage) {
+	snap := st.Snapshot()
+	ids := slices.Sorted(maps.Keys(snap.Films))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tADDED BY\tPOSTER\tTRAILER")
+	for _, id := range ids {
+		f := snap.Films[id]
+		fmt.Fprintf(w, "%d\t%s\t%d (%s)\t%t\t%s\n", id, f.Name, f.Added, snap.Users[f.Added].Name, f.Poster != "", f.Trailer)
+	}
+	w.Flush()
+}
+
+func listVotes(st *storage.Storage) {
+	for _, stat := range st.StatusFull() {
+		fmt.Printf("%s [%d] - %d\n", stat.Name, stat.Id, stat.Votes)
+		for _, u := range stat.Voters {
+			fmt.Printf("    %s (@%s)\n", u.Name, u.Username)
+		}
+	}
+}
+
+func validate(st *storage.Storage, fix bool) {
+	if !fix {
+		problems := st.Validate()
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+		fmt.Println("ok")
+		return
+	}
+
+	fixed, remaining, err := st.Repair()
+	if err != nil {
+		fail(err)
+	}
+	for _, p := range fixed {
+		fmt.Println("fixed: " + p)
+	}
+	for _, p := range remaining {
+		fmt.Println("remaining: " + p)
+	}
+	if len(remaining) > 0 {
+		os.Exit(1)
+	}
+	if len(fixed) == 0 {
+		fmt.Println("ok")
+	}
+}
+
+func listEvents(st *storage.Storage, n int) {
+	events, err := st.Events(nil, n)
+	if err != nil {
+		fail(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tTYPE\tUSER\tFILM\tNAME\tBEFORE\tAFTER")
+	for _, e := range events {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%d\t%d\n",
+			time.Unix(e.Time, 0).Format(time.DateTime), e.Type, e.User, e.Film, e.Name, e.Before, e.After)
+	}
+	w.Flush()
+}
+
+func replay(st *storage.Storage, eventsPath string, write bool, force bool) {
+	f, err := os.Open(eventsPath)
+	if err != nil {
+		fail(err)
+	}
+	replayed, err := storage.Replay(f)
+	f.Close()
+	if err != nil {
+		fail(err)
+	}
+
+	cur := st.Snapshot()
+	diff := 0
+	for _, id := range slices.Sorted(maps.Keys(cur.Films)) {
+		if r, ok := replayed.Films[id]; !ok || r.Name != cur.Films[id].Name {
+			fmt.Printf("film %d: %q, replayed %q\n", id, cur.Films[id].Name, r.Name)
+			diff++
+		}
+	}
+	for _, id := range slices.Sorted(maps.Keys(replayed.Films)) {
+		if _, ok := cur.Films[id]; !ok {
+			fmt.Printf("film %d: missing, replayed %q\n", id, replayed.Films[id].Name)
+			diff++
+		}
+	}
+	for _, id := range slices.Sorted(maps.Keys(cur.Users)) {
+		if r, ok := replayed.Users[id]; !ok || r.Vote != cur.Users[id].Vote {
+			fmt.Printf("user %d (%s): vote %d, replayed %d (registered in log: %t)\n", id, cur.Users[id].Name, cur.Users[id].Vote, r.Vote, ok)
+			diff++
+		}
+	}
+	for _, id := range slices.Sorted(maps.Keys(replayed.Users)) {
+		if _, ok := cur.Users[id]; !ok {
+			fmt.Printf("user %d: missing, replayed %q\n", id, replayed.Users[id].Name)
+			diff++
+		}
+	}
+	fmt.Printf("%d differences\n", diff)
+	if !replayed.Complete {
+		fmt.Println("the log doesn't start with a snapshot: users and films unchanged since it was started are missing")
+	}
+
+	if write {
+		if err := st.Rebuild(force); err != nil {
+			if errors.Is(err, storage.ErrIncompleteLog) {
+				err = fmt.Errorf("%w, add -force to merge the replayed state over the stored one", err)
+			}
+			fail(err)
+		}
+		fmt.Println("state rebuilt from the event log")
+	}
+}
+
+func needArgs(args []string, n int) {
+	if len(args) < n {
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func report(ok bool, success string, failure string) {
+	if ok {
+		fmt.Println(success)
+		return
+	}
+	fmt.Println(failure)
+	os.Exit(1)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "votectl: "+err.Error())
+	os.Exit(1)
+}
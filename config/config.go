@@ -18,10 +18,55 @@ type Config struct {
 
 	FetchInterval time.Duration `yaml:"polling_interval"`
 
+	// language of the main chat and of users whose language isn't supported, "ru" by default
+	Language string `yaml:"language"`
+	// directory with <lang>.yaml files overriding single messages of bot/locales or adding languages
+	Templates string `yaml:"templates"`
+
+	// how /monitor announces posters: "" (none), "album" or "photos"
+	PosterMode string `yaml:"poster_mode"`
+
+	// how ties are broken: "" (kept), "added", "seniority", "random" or "runoff"
+	TieBreak       string        `yaml:"tie_break"`
+	RunoffDuration time.Duration `yaml:"runoff_duration"`
+
+	// how long before a screening "going" members are reminded
+	ScreeningReminder time.Duration `yaml:"screening_reminder"`
+
+	Jobs []Job `yaml:"jobs"`
+
+	Eligibility Eligibility `yaml:"eligibility"`
+	Weights     Weights     `yaml:"weights"`
+
 	Limit  int `yaml:"limit"`
 	Offset int `yaml:"offset"`
 }
 
+// Job is a scheduled job: "nudge" DMs members who haven't voted,
+// "closing" warns the main chat, "digest" posts a summary.
+// It runs either by a cron expression or once before each deadline.
+type Job struct {
+	Job            string        `yaml:"job"`
+	Cron           string        `yaml:"cron"`
+	BeforeDeadline time.Duration `yaml:"before_deadline"`
+}
+
+// Eligibility limits who can vote, empty allows everyone who sent /start
+type Eligibility struct {
+	// only current members of MainChatId
+	MembersOnly bool `yaml:"members_only"`
+	// attended at least Attended of the last Of sessions
+	Attended int `yaml:"attended"`
+	Of       int `yaml:"of"`
+}
+
+// Weights give extra votes, empty keeps one vote for everyone
+type Weights struct {
+	// bonus for members whose films haven't won for LoserStreak sessions
+	LoserBonus  int `yaml:"loser_bonus"`
+	LoserStreak int `yaml:"loser_streak"`
+}
+
 func MustLoad() *Config {
 	path := fetchConfigPath()
 	f, err := os.Open(path)
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// Repair fixes problems reported by Validate. It returns what was fixed
+// and what is still wrong after that.
+func (s *Storage) Repair() ([]string, []string, error) {
+	var fixed, remaining []string
+	err := s.commit(func() (changes, error) {
+		found := s.check(true)
+		remaining = s.check(false)
+		fixed = slices.DeleteFunc(found, func(p string) bool {
+			return slices.Contains(remaining, p)
+		})
+		if len(fixed) == 0 {
+			return 0, nil
+		}
+		return changedUsers | changedFilms | changedUtil, nil
+	})
+
+	return fixed, remaining, err
+}
+
+// Validate checks referential integrity of the stored data and returns found problems
+func (s *Storage) Validate() []string {
+	s.filmsMu.RLock()
+	defer s.filmsMu.RUnlock()
+	s.usersMu.RLock()
+	defer s.usersMu.RUnlock()
+	s.utilMu.RLock()
+	defer s.utilMu.RUnlock()
+
+	return s.check(false)
+}
+
+// check must be called under locks, fix requires write locks and records
+// the repairs to the log, so the state replayed from it agrees
+func (s *Storage) check(fix bool) []string {
+	var problems []string
+
+	maxID := 0
+	for id, info := range s.films {
+		maxID = max(maxID, id)
+		if _, ok := s.users[info.Added]; !ok && info.Added != 0 {
+			problems = append(problems, fmt.Sprintf("film %d %q is added by unknown user %d", id, info.Name, info.Added))
+			if fix {
+				// as if the user was forgotten
+				s.record(Event{Type: EventDisown, User: info.Added, Film: id})
+				info.Added = 0
+				s.films[id] = info
+			}
+		}
+	}
+	if s.util.IdCnt < maxID {
+		problems = append(problems, fmt.Sprintf("id_cnt=%d is lower than max film id %d", s.util.IdCnt, maxID))
+		if fix {
+			s.util.IdCnt = maxID
+		}
+	}
+
+	for id, info := range s.users {
+		if info.Vote == 0 {
+			continue
+		}
+		if _, ok := s.films[info.Vote]; !ok {
+			problems = append(problems, fmt.Sprintf("user %d (%s) votes for deleted film %d", id, info.Name, info.Vote))
+			if fix {
+				s.record(Event{Type: EventRetract, User: id, Before: info.Vote})
+				info.Vote = 0
+				s.users[id] = info
+			}
+		}
+	}
+
+	sort.Strings(problems)
+	return problems
+}
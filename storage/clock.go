@@ -0,0 +1,27 @@
+package storage
+
+import "time"
+
+// Clock tells the time to the storage: deadlines are checked and events are stamped by it
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// SetClock replaces the system clock, it should be called before the storage is used
+func (s *Storage) SetClock(c Clock) {
+	s.clock = c
+}
+
+// Now returns the time of the storage clock
+func (s *Storage) Now() time.Time {
+	return s.clock.Now()
+}
+
+// deadlinePassed must be called with util locked
+func (s *Storage) deadlinePassed() bool {
+	return s.util.Deadline != 0 && s.Now().Unix() >= s.util.Deadline
+}
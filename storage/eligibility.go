@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var (
+	ErrNotMember    = errors.New("not a member of the main chat")
+	ErrNoAttendance = errors.New("not enough attendance")
+)
+
+// Rules decide whose votes count and how much
+type Rules struct {
+	// only current members of the main chat, see SetMember
+	MembersOnly bool
+	// at least Attended of the last Of screenings with recorded attendance, disabled if Of is 0.
+	// While the club has fewer screenings, all of them are required at most.
+	Attended int
+	Of       int
+
+	// extra weight for proposers whose films haven't won for LoserStreak sessions in a row
+	LoserBonus  int
+	LoserStreak int
+}
+
+// Validate rejects rules that would count votes differently from what they say
+func (r Rules) Validate() error {
+	switch {
+	case r.Attended < 0 || r.Of < 0:
+		return fmt.Errorf("attendance %d of %d is negative", r.Attended, r.Of)
+	case r.Of > 0 && r.Attended > r.Of:
+		return fmt.Errorf("attendance %d of %d can't be met", r.Attended, r.Of)
+	case r.LoserBonus < 0:
+		return fmt.Errorf("loser bonus %d is negative", r.LoserBonus)
+	// a streak of 0 sessions would give the bonus to every proposer
+	case r.LoserBonus > 0 && r.LoserStreak < 1:
+		return fmt.Errorf("loser bonus needs a loser streak of at least 1 session, got %d", r.LoserStreak)
+	}
+	return nil
+}
+
+// Voter is a vote as it is counted
+type Voter struct {
+	UserInfo
+	Id     int64
+	Weight int
+	// why the vote is not counted, nil if it is
+	Err error
+}
+
+// SetRules sets eligibility rules, it should be called before the storage is used
+func (s *Storage) SetRules(r Rules) {
+	s.utilMu.Lock()
+	defer s.utilMu.Unlock()
+	s.rules = r
+}
+
+func (s *Storage) Rules() Rules {
+	s.utilMu.RLock()
+	defer s.utilMu.RUnlock()
+	return s.rules
+}
+
+// Ballot returns the weight of the user's vote or why it is not counted
+func (s *Storage) Ballot(userID int64) (int, error) {
+	s.filmsMu.RLock()
+	defer s.filmsMu.RUnlock()
+	s.usersMu.RLock()
+	defer s.usersMu.RUnlock()
+	s.utilMu.RLock()
+	defer s.utilMu.RUnlock()
+
+	return s.ballot(userID)
+}
+
+// SetMember records whether the user is in the main chat
+func (s *Storage) SetMember(userID int64, member bool) error {
+	return s.commit(func() (changes, error) {
+		usr, ok := s.users[userID]
+		if !ok {
+			return 0, fmt.Errorf("no userID=%d: %w", userID, ErrUnknownUser)
+		}
+		if usr.Left == !member {
+			return 0, nil
+		}
+		usr.Left = !member
+		s.users[userID] = usr
+		return changedUsers, nil
+	})
+}
+
+// ballot must be called with films, users and util locked
+func (s *Storage) ballot(userID int64) (int, error) {
+	if s.rules.MembersOnly && s.users[userID].Left {
+		return 0, ErrNotMember
+	}
+
+	if s.rules.Of > 0 {
+		screenings := s.attendedScreenings()
+		recent := screenings[max(0, len(screenings)-s.rules.Of):]
+		attended := 0
+		for _, sc := range recent {
+			if _, ok := slices.BinarySearch(sc.Attended, userID); ok {
+				attended++
+			}
+		}
+		if attended < min(s.rules.Attended, len(recent)) {
+			return 0, ErrNoAttendance
+		}
+	}
+
+	weight := 1
+	if s.rules.LoserBonus > 0 && s.losingStreak(userID) >= s.rules.LoserStreak {
+		weight += s.rules.LoserBonus
+	}
+	return weight, nil
+}
+
+// losingStreak counts sessions since the user's film last won.
+// Users without films in the list and sessions before joining don't count.
+func (s *Storage) losingStreak(userID int64) int {
+	proposes := false
+	for _, info := range s.films {
+		if info.Added == userID {
+			proposes = true
+			break
+		}
+	}
+	if !proposes {
+		return 0
+	}
+
+	joined := s.users[userID].Joined
+	streak := 0
+	for _, rec := range slices.Backward(s.util.Sessions) {
+		if rec.Closed < joined {
+			break
+		}
+		if slices.ContainsFunc(rec.Winners, func(f SessionFilm) bool { return f.AddedBy == userID }) {
+			break
+		}
+		streak++
+	}
+	return streak
+}
@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+)
+
+const eventsFile = "events.jsonl"
+
+type EventType string
+
+const (
+	EventRegister EventType = "register"
+	EventAdd      EventType = "add"
+	EventRemove   EventType = "remove"
+	EventRename   EventType = "rename"
+	EventVote     EventType = "vote"
+	EventRetract  EventType = "retract"
+	EventReset    EventType = "reset"
+	EventRunoff   EventType = "runoff"
+	EventProfile  EventType = "profile"
+	// a repair removed the unknown author of a film, User is that author
+	EventDisown EventType = "disown"
+	// the state the log starts from, the first event of a new log
+	EventSnapshot EventType = "snapshot"
+)
+
+// ErrIncompleteLog means the log doesn't start with a snapshot: it was started
+// over existing data, so replaying it misses what happened before
+var ErrIncompleteLog = errors.New("the event log doesn't start with a snapshot")
+
+// Event is a line of the append-only log. Before/After hold votes for vote and
+// retract, the session for reset. Name is the film name for film events
+// (the new one for rename) and the user name for register and profile.
+// State is only set for snapshot.
+type Event struct {
+	Time     int64     `json:"time"`
+	Type     EventType `json:"type"`
+	User     int64     `json:"user,omitempty"`
+	Film     int       `json:"film,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	Username string    `json:"username,omitempty"`
+	Before   int       `json:"before,omitempty"`
+	After    int       `json:"after,omitempty"`
+	State    *Snapshot `json:"state,omitempty"`
+}
+
+// record queues an event, it must be called inside commit
+func (s *Storage) record(e Event) {
+	e.Time = s.Now().Unix()
+	s.pending = append(s.pending, e)
+}
+
+// startLog begins a new log with a snapshot, so the state can be replayed from it.
+// An existing log is kept as is.
+func (s *Storage) startLog() error {
+	if fi, err := os.Stat(s.eventsPath); err == nil && fi.Size() > 0 {
+		return nil
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return s.commit(func() (changes, error) {
+		s.record(Event{Type: EventSnapshot, State: &Snapshot{
+			Users:   maps.Clone(s.users),
+			Films:   maps.Clone(s.films),
+			Session: s.util.Session,
+		}})
+		return 0, nil
+	})
+}
+
+func (s *Storage) flushEvents() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	defer func() { s.pending = s.pending[:0] }()
+
+	f, err := os.OpenFile(s.eventsPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range s.pending {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// Events returns logged events matching filter (all if nil), the newest limit of them
+func (s *Storage) Events(filter func(Event) bool, limit int) ([]Event, error) {
+	s.utilMu.RLock()
+	defer s.utilMu.RUnlock()
+
+	f, err := os.Open(s.eventsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var res []Event
+	err = readEvents(f, func(e Event) {
+		if filter == nil || filter(e) {
+			res = append(res, e)
+		}
+	})
+	if limit > 0 && len(res) > limit {
+		res = res[len(res)-limit:]
+	}
+
+	return res, err
+}
+
+func readEvents(r io.Reader, f func(Event)) error {
+	dec := json.NewDecoder(r)
+	for {
+		var e Event
+		err := dec.Decode(&e)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode event: %w", err)
+		}
+		f(e)
+	}
+}
+
+// Replayed is the state built from the event log
+type Replayed struct {
+	Snapshot
+	IdCnt int
+	// the log starts with a snapshot, otherwise users and films
+	// that haven't changed since the log was started are missing
+	Complete bool
+}
+
+// Replay builds users, films and counters from the event log only
+func Replay(r io.Reader) (Replayed, error) {
+	snap := Snapshot{
+		Users: map[int64]UserInfo{},
+		Films: map[int]FilmInfo{},
+	}
+	idCnt := 0
+	first, complete := true, false
+
+	err := readEvents(r, func(e Event) {
+		if first {
+			complete = e.Type == EventSnapshot && e.State != nil
+			first = false
+		}
+		switch e.Type {
+		case EventSnapshot:
+			if e.State == nil {
+				return
+			}
+			snap.Users = maps.Clone(e.State.Users)
+			snap.Films = maps.Clone(e.State.Films)
+			snap.Session = e.State.Session
+			if snap.Users == nil {
+				snap.Users = map[int64]UserInfo{}
+			}
+			if snap.Films == nil {
+				snap.Films = map[int]FilmInfo{}
+			}
+			for id := range snap.Films {
+				idCnt = max(idCnt, id)
+			}
+		case EventRegister:
+			snap.Users[e.User] = UserInfo{Name: e.Name, Username: e.Username, Joined: e.Time}
+		case EventProfile:
+			if info, ok := snap.Users[e.User]; ok {
+				info.Name, info.Username = e.Name, e.Username
+				snap.Users[e.User] = info
+			}
+		case EventAdd:
+			snap.Films[e.Film] = FilmInfo{Name: e.Name, Added: e.User, AddedAt: e.Time}
+			idCnt = max(idCnt, e.Film)
+		case EventRename:
+			if info, ok := snap.Films[e.Film]; ok {
+				info.Name = e.Name
+				snap.Films[e.Film] = info
+			}
+		case EventDisown:
+			if info, ok := snap.Films[e.Film]; ok {
+				info.Added = 0
+				snap.Films[e.Film] = info
+			}
+		case EventRemove:
+			delete(snap.Films, e.Film)
+			for id, info := range snap.Users {
+				if info.Vote == e.Film {
+					info.Vote = 0
+					snap.Users[id] = info
+				}
+			}
+		case EventVote, EventRetract:
+			if info, ok := snap.Users[e.User]; ok {
+				info.Vote = e.After
+				snap.Users[e.User] = info
+			}
+		case EventReset:
+			for id, info := range snap.Users {
+				info.Vote = 0
+				snap.Users[id] = info
+			}
+			snap.Session = e.After
+		case EventRunoff:
+			for id, info := range snap.Users {
+				info.Vote = 0
+				snap.Users[id] = info
+			}
+		}
+	})
+
+	return Replayed{Snapshot: snap, IdCnt: idCnt, Complete: complete}, err
+}
+
+// Rebuild replaces users, films and counters with the state replayed from the log.
+// Fields that are not logged (inactive flags, profile details, posters, trailers) are kept.
+// A log that doesn't start with a snapshot is refused with ErrIncompleteLog unless force
+// is set, then the replayed users and films are merged over the stored ones instead.
+func (s *Storage) Rebuild(force bool) error {
+	f, err := os.Open(s.eventsPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	replayed, err := Replay(f)
+	if err != nil {
+		return err
+	}
+	if !replayed.Complete && !force {
+		return ErrIncompleteLog
+	}
+	snap, idCnt := replayed.Snapshot, replayed.IdCnt
+
+	return s.commit(func() (changes, error) {
+		for id, info := range snap.Users {
+			old := s.users[id]
+			info.Inactive = old.Inactive
+			info.Left = old.Left
+			info.Quit = old.Quit
+			info.LastName = old.LastName
+			info.LanguageCode = old.LanguageCode
+			info.LastSeen = old.LastSeen
+			info.Language = old.Language
+			snap.Users[id] = info
+		}
+		for id, info := range snap.Films {
+			info.Poster = s.films[id].Poster
+			info.Trailer = s.films[id].Trailer
+			snap.Films[id] = info
+		}
+		if replayed.Complete {
+			s.users = snap.Users
+			s.films = snap.Films
+		} else {
+			maps.Copy(s.users, snap.Users)
+			maps.Copy(s.films, snap.Films)
+		}
+		s.util.IdCnt = max(s.util.IdCnt, idCnt)
+		s.util.Session = snap.Session
+		return changedUsers | changedFilms | changedUtil, nil
+	})
+}
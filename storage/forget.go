@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Forgotten tells what Forget deleted
+type Forgotten struct {
+	Vote     bool
+	Films    int
+	RSVPs    int
+	Sessions int
+	Events   int
+}
+
+// Leave retracts the user's vote and stops accepting new ones, Rejoin brings them back
+func (s *Storage) Leave(userID int64) error {
+	return s.commit(func() (changes, error) {
+		usr, ok := s.users[userID]
+		if !ok {
+			return 0, fmt.Errorf("no userID=%d: %w", userID, ErrUnknownUser)
+		}
+		if usr.Vote != 0 {
+			s.record(Event{Type: EventRetract, User: userID, Before: usr.Vote})
+		}
+		usr.Vote = 0
+		usr.Quit = true
+		s.users[userID] = usr
+		return changedUsers, nil
+	})
+}
+
+// Rejoin undoes Leave
+func (s *Storage) Rejoin(userID int64) error {
+	return s.commit(func() (changes, error) {
+		usr, ok := s.users[userID]
+		if !ok {
+			return 0, fmt.Errorf("no userID=%d: %w", userID, ErrUnknownUser)
+		}
+		if !usr.Quit {
+			return 0, nil
+		}
+		usr.Quit = false
+		s.users[userID] = usr
+		return changedUsers, nil
+	})
+}
+
+// Forget deletes the user's personal data: the profile and the vote are removed,
+// films, session records, screenings and the event log keep no trace of the user id.
+func (s *Storage) Forget(userID int64) (Forgotten, error) {
+	var res Forgotten
+	err := s.commit(func() (changes, error) {
+		usr, ok := s.users[userID]
+		if !ok {
+			return 0, fmt.Errorf("no userID=%d: %w", userID, ErrUnknownUser)
+		}
+		// the log is rewritten first, so a failure leaves the state as it was
+		n, err := s.anonymizeEvents(userID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to anonymize events: %w", err)
+		}
+		res.Events = n
+
+		res.Vote = usr.Vote != 0
+		delete(s.users, userID)
+
+		for id, info := range s.films {
+			if info.Added == userID {
+				info.Added = 0
+				s.films[id] = info
+				res.Films++
+			}
+		}
+
+		for i := range s.util.Sessions {
+			rec := &s.util.Sessions[i]
+			found := false
+			if j, ok := slices.BinarySearch(rec.Voters, userID); ok {
+				rec.Voters = slices.Delete(rec.Voters, j, j+1)
+				found = true
+			}
+			for j := range rec.Winners {
+				if rec.Winners[j].AddedBy == userID {
+					rec.Winners[j].AddedBy = 0
+					found = true
+				}
+			}
+			if found {
+				res.Sessions++
+			}
+		}
+
+		for i := range s.util.Screenings {
+			sc := &s.util.Screenings[i]
+			if _, ok := sc.RSVP[userID]; ok {
+				delete(sc.RSVP, userID)
+				res.RSVPs++
+			}
+			if j, ok := slices.BinarySearch(sc.Attended, userID); ok {
+				sc.Attended = slices.Delete(sc.Attended, j, j+1)
+			}
+		}
+
+		return changedUsers | changedFilms | changedUtil, nil
+	})
+
+	return res, err
+}
+
+// forgetState removes the user from a logged snapshot and reports if it was there
+func forgetState(snap *Snapshot, userID int64) bool {
+	_, found := snap.Users[userID]
+	delete(snap.Users, userID)
+	for id, info := range snap.Films {
+		if info.Added == userID {
+			info.Added = 0
+			snap.Films[id] = info
+			found = true
+		}
+	}
+	return found
+}
+
+// anonymizeEvents rewrites the log without the user: their registration and
+// profile events are dropped, other events keep no user id, snapshots lose
+// the profile and the authorship of films.
+// It must be called inside commit.
+func (s *Storage) anonymizeEvents(userID int64) (int, error) {
+	f, err := os.Open(s.eventsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var events []Event
+	n := 0
+	err = readEvents(f, func(e Event) {
+		if e.State != nil && forgetState(e.State, userID) {
+			n++
+		}
+		if e.User != userID {
+			events = append(events, e)
+			return
+		}
+		n++
+		if e.Type == EventRegister || e.Type == EventProfile {
+			return
+		}
+		e.User = 0
+		events = append(events, e)
+	})
+	f.Close()
+	if err != nil || n == 0 {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.eventsPath), filepath.Base(s.eventsPath)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			tmp.Close()
+			return 0, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return 0, err
+	}
+
+	return n, os.Rename(tmp.Name(), s.eventsPath)
+}
@@ -0,0 +1,18 @@
+package storage
+
+// JobState returns what a scheduled job saved on its last run, 0 if it never ran
+func (s *Storage) JobState(name string) int64 {
+	s.utilMu.RLock()
+	defer s.utilMu.RUnlock()
+	return s.util.Jobs[name]
+}
+
+func (s *Storage) SetJobState(name string, state int64) error {
+	return s.commit(func() (changes, error) {
+		if s.util.Jobs == nil {
+			s.util.Jobs = map[string]int64{}
+		}
+		s.util.Jobs[name] = state
+		return changedUtil, nil
+	})
+}
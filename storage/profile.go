@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// profiles are saved at most once per lastSeenStep unless they change
+const lastSeenStep = time.Minute
+
+// Profile is what Telegram tells about a user on every update
+type Profile struct {
+	Name         string
+	LastName     string
+	Username     string
+	LanguageCode string
+}
+
+// FullName is the first and the last name
+func (u UserInfo) FullName() string {
+	if u.LastName == "" {
+		return u.Name
+	}
+	return u.Name + " " + u.LastName
+}
+
+// UpdateProfile refreshes a registered user's profile and last seen time.
+// It is called on every update, so the usual case of nothing to save takes only a read lock.
+func (s *Storage) UpdateProfile(userID int64, p Profile) error {
+	now := s.Now().Unix()
+	s.usersMu.RLock()
+	usr, ok := s.users[userID]
+	s.usersMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no userID=%d: %w", userID, ErrUnknownUser)
+	}
+	if usr.profileUpToDate(p, now) {
+		return nil
+	}
+
+	return s.commit(func() (changes, error) {
+		usr, ok := s.users[userID]
+		if !ok {
+			return 0, fmt.Errorf("no userID=%d: %w", userID, ErrUnknownUser)
+		}
+		// another update could have saved it meanwhile
+		if usr.profileUpToDate(p, now) {
+			return 0, nil
+		}
+
+		if usr.Name != p.Name || usr.Username != p.Username {
+			s.record(Event{Type: EventProfile, User: userID, Name: p.Name, Username: p.Username})
+		}
+		usr.Name = p.Name
+		usr.LastName = p.LastName
+		usr.Username = p.Username
+		usr.LanguageCode = p.LanguageCode
+		usr.LastSeen = now
+		s.users[userID] = usr
+		return changedUsers, nil
+	})
+}
+
+// profileUpToDate reports whether the profile is saved and the last seen time is recent enough
+func (u UserInfo) profileUpToDate(p Profile, now int64) bool {
+	return u.Name == p.Name && u.Username == p.Username && u.LastName == p.LastName &&
+		u.LanguageCode == p.LanguageCode && now-u.LastSeen < int64(lastSeenStep/time.Second)
+}
+
+// SetLanguage saves the language chosen by the user, empty follows the Telegram client
+func (s *Storage) SetLanguage(userID int64, lang string) error {
+	return s.commit(func() (changes, error) {
+		usr, ok := s.users[userID]
+		if !ok {
+			return 0, fmt.Errorf("no userID=%d: %w", userID, ErrUnknownUser)
+		}
+		usr.Language = lang
+		s.users[userID] = usr
+		return changedUsers, nil
+	})
+}
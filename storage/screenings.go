@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+var ErrUnknownScreening = errors.New("unknown screening")
+
+type RSVP string
+
+const (
+	RSVPGoing RSVP = "going"
+	RSVPMaybe RSVP = "maybe"
+	RSVPNo    RSVP = "no"
+)
+
+type Screening struct {
+	Id    int    `json:"id"`
+	Time  int64  `json:"time"`
+	Place string `json:"place"`
+	Film  string `json:"film"`
+
+	// the RSVP message
+	ChatId int64 `json:"chat_id"`
+	MsgId  int64 `json:"message_id"`
+
+	RSVP     map[int64]RSVP `json:"rsvp"`
+	Reminded bool           `json:"reminded,omitempty"`
+	// who actually came, nil until recorded, empty if nobody did
+	Attended []int64 `json:"attended"`
+}
+
+func (sc Screening) clone() Screening {
+	sc.RSVP = maps.Clone(sc.RSVP)
+	sc.Attended = slices.Clone(sc.Attended)
+	return sc
+}
+
+// Responded returns users with the given answer ordered by id
+func (sc Screening) Responded(answer RSVP) []int64 {
+	var res []int64
+	for id, a := range sc.RSVP {
+		if a == answer {
+			res = append(res, id)
+		}
+	}
+	slices.Sort(res)
+	return res
+}
+
+// AddScreening stores a new screening and returns its id
+func (s *Storage) AddScreening(sc Screening) (int, error) {
+	err := s.commit(func() (changes, error) {
+		s.util.ScreeningCnt++
+		sc.Id = s.util.ScreeningCnt
+		sc.RSVP = map[int64]RSVP{}
+		s.util.Screenings = append(s.util.Screenings, sc)
+		return changedUtil, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return sc.Id, nil
+}
+
+func (s *Storage) SetScreeningMessage(id int, chatID int64, msgID int64) error {
+	return s.updateScreening(id, func(sc *Screening) error {
+		sc.ChatId, sc.MsgId = chatID, msgID
+		return nil
+	})
+}
+
+func (s *Storage) SetRSVP(id int, userID int64, answer RSVP) error {
+	return s.updateScreening(id, func(sc *Screening) error {
+		if _, ok := s.users[userID]; !ok {
+			return fmt.Errorf("no userID=%d: %w", userID, ErrUnknownUser)
+		}
+		sc.RSVP[userID] = answer
+		return nil
+	})
+}
+
+func (s *Storage) SetReminded(id int) error {
+	return s.updateScreening(id, func(sc *Screening) error {
+		sc.Reminded = true
+		return nil
+	})
+}
+
+// SetAttended records who came to the screening, replacing the previous record
+func (s *Storage) SetAttended(id int, users []int64) error {
+	users = slices.Clone(users)
+	slices.Sort(users)
+	users = slices.Compact(users)
+	if users == nil {
+		users = []int64{}
+	}
+	return s.updateScreening(id, func(sc *Screening) error {
+		sc.Attended = users
+		return nil
+	})
+}
+
+func (s *Storage) updateScreening(id int, f func(sc *Screening) error) error {
+	return s.commit(func() (changes, error) {
+		i := slices.IndexFunc(s.util.Screenings, func(sc Screening) bool { return sc.Id == id })
+		if i < 0 {
+			return 0, fmt.Errorf("no screening=%d: %w", id, ErrUnknownScreening)
+		}
+		if err := f(&s.util.Screenings[i]); err != nil {
+			return 0, err
+		}
+		return changedUtil, nil
+	})
+}
+
+func (s *Storage) Screening(id int) (Screening, bool) {
+	s.utilMu.RLock()
+	defer s.utilMu.RUnlock()
+
+	for _, sc := range s.util.Screenings {
+		if sc.Id == id {
+			return sc.clone(), true
+		}
+	}
+	return Screening{}, false
+}
+
+// Screenings returns all screenings, the oldest first
+func (s *Storage) Screenings() []Screening {
+	s.utilMu.RLock()
+	defer s.utilMu.RUnlock()
+
+	res := make([]Screening, len(s.util.Screenings))
+	for i, sc := range s.util.Screenings {
+		res[i] = sc.clone()
+	}
+	return res
+}
+
+// attendedScreenings returns screenings with recorded attendance by time, it must be called with util locked
+func (s *Storage) attendedScreenings() []Screening {
+	var res []Screening
+	for _, sc := range s.util.Screenings {
+		if sc.Attended != nil {
+			res = append(res, sc)
+		}
+	}
+	slices.SortStableFunc(res, func(a, b Screening) int { return cmp.Compare(a.Time, b.Time) })
+	return res
+}
@@ -0,0 +1,63 @@
+package storage
+
+import "slices"
+
+// SessionRecord is the result of a closed voting session
+type SessionRecord struct {
+	Session int           `json:"session"`
+	Closed  int64         `json:"closed"`
+	Winners []SessionFilm `json:"winners"`
+	Voters  []int64       `json:"voters"`
+	Seed    uint64        `json:"seed,omitempty"`
+}
+
+type SessionFilm struct {
+	Id      int    `json:"id"`
+	Name    string `json:"name"`
+	AddedBy int64  `json:"added_by"`
+	Votes   int    `json:"votes"`
+}
+
+// closeSession builds the record of the current session, it must be called inside commit.
+// Sessions without votes are not recorded.
+func (s *Storage) closeSession() (SessionRecord, bool) {
+	rec := SessionRecord{
+		Session: s.util.Session,
+		Closed:  s.Now().Unix(),
+	}
+
+	for id, info := range s.users {
+		if _, ok := s.films[info.Vote]; ok {
+			rec.Voters = append(rec.Voters, id)
+		}
+	}
+	if len(rec.Voters) == 0 {
+		return rec, false
+	}
+	slices.Sort(rec.Voters)
+
+	// without a decided leader all tied films count as winners
+	stats := s.status(false)
+	for i := range stats {
+		if stats[i].Votes != stats[0].Votes || (i > 0 && stats[0].Leader) {
+			break
+		}
+		info := s.films[stats[i].Id]
+		rec.Winners = append(rec.Winners, SessionFilm{
+			Id:      stats[i].Id,
+			Name:    info.Name,
+			AddedBy: info.Added,
+			Votes:   stats[i].Votes,
+		})
+	}
+	rec.Seed = s.util.Seed
+
+	return rec, true
+}
+
+// Sessions returns records of closed sessions, the oldest first
+func (s *Storage) Sessions() []SessionRecord {
+	s.utilMu.RLock()
+	defer s.utilMu.RUnlock()
+	return slices.Clone(s.util.Sessions)
+}
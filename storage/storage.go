@@ -1,11 +1,17 @@
 package storage
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"os"
 	"path"
-	"sort"
+	"slices"
 	"sync"
+	"time"
 )
 
 const (
@@ -14,14 +20,31 @@ const (
 	utilFile  = "util.json"
 )
 
+var (
+	ErrUnknownUser = errors.New("unknown user")
+	ErrUnknownFilm = errors.New("unknown film")
+	ErrQuit        = errors.New("the user left the club")
+	ErrReadOnly    = errors.New("storage is opened read-only")
+)
+
 type Storage struct {
 	users map[int64]UserInfo
 	films map[int]FilmInfo
 	util  util
 
-	usersPath string
-	filmsPath string
-	utilPath  string
+	usersPath  string
+	filmsPath  string
+	utilPath   string
+	eventsPath string
+
+	// events recorded by the running commit
+	pending []Event
+
+	tieBreak TieBreak
+	rules    Rules
+	// opened by NewReadOnly, nothing is written to the data directory
+	readOnly bool
+	clock    Clock
 
 	usersMu sync.RWMutex
 	filmsMu sync.RWMutex
@@ -29,215 +52,446 @@ type Storage struct {
 }
 
 type UserInfo struct {
-	Name     string `json:"name"`
-	Username string `json:"username"`
-	Vote     int    `json:"vote"`
+	Name         string `json:"name"`
+	LastName     string `json:"last_name,omitempty"`
+	Username     string `json:"username"`
+	LanguageCode string `json:"language_code,omitempty"`
+	Vote         int    `json:"vote"`
+	Inactive     bool   `json:"inactive,omitempty"` // blocked the bot
+	Joined       int64  `json:"joined,omitempty"`
+	LastSeen     int64  `json:"last_seen,omitempty"`
+	// chosen with /lang, overrides LanguageCode
+	Language string `json:"language,omitempty"`
+	// left the main chat, see Rules.MembersOnly
+	Left bool `json:"left,omitempty"`
+	// left the club with /leave
+	Quit bool `json:"quit,omitempty"`
 }
 
 type FilmInfo struct {
-	Name  string `json:"name"`
-	Added int64  `json:"added_by"`
+	Name    string `json:"name"`
+	Added   int64  `json:"added_by"`
+	AddedAt int64  `json:"added_at,omitempty"`
+
+	// Telegram file_id of the poster, reused without uploading again
+	Poster  string `json:"poster,omitempty"`
+	Trailer string `json:"trailer,omitempty"`
+}
+
+// Snapshot is a copy of the stored data
+type Snapshot struct {
+	Users   map[int64]UserInfo `json:"users"`
+	Films   map[int]FilmInfo   `json:"films"`
+	Session int                `json:"session"`
 }
 
 type FilmStat struct {
-	Id      int
-	Name    string
-	Votes   int
-	Voters  []UserInfo
-	AddedBy UserInfo
+	Id   int
+	Name string
+	// weighted sum of counted votes
+	Votes int
+	// number of counted votes
+	Ballots int
+	// all votes including not counted ones, only in StatusFull
+	Voters    []Voter
+	AddedBy   UserInfo
+	AddedById int64
+	Poster    string
+	Trailer   string
+
+	// the film wins even if others have as many votes, see TieBreak
+	Leader bool
 }
 
 func New(dataPath string) (*Storage, error) {
+	s, err := load(dataPath)
+	if err != nil {
+		return nil, err
+	}
+	if s.util.Seed == 0 {
+		if err := s.commit(func() (changes, error) {
+			s.util.Seed = rand.Uint64()
+			return changedUtil, nil
+		}); err != nil {
+			return nil, fmt.Errorf("failed to save session seed: %w", err)
+		}
+	}
+	if err := s.startLog(); err != nil {
+		return nil, fmt.Errorf("failed to start the event log: %w", err)
+	}
+
+	return s, nil
+}
+
+// NewReadOnly opens the storage for inspection, e.g. while the bot is running on it.
+// Changes fail with ErrReadOnly.
+func NewReadOnly(dataPath string) (*Storage, error) {
+	s, err := load(dataPath)
+	if err != nil {
+		return nil, err
+	}
+	s.readOnly = true
+	return s, nil
+}
+
+// load reads the data files, a missing one is empty as in a new deployment
+func load(dataPath string) (*Storage, error) {
+	if _, err := os.Stat(dataPath); err != nil {
+		return nil, fmt.Errorf("invalid data directory: %w", err)
+	}
+
 	usersPath := path.Join(dataPath, usersFile)
 	users := map[int64]UserInfo{}
-	if err := loadFromFileJSON(usersPath, &users); err != nil {
+	if err := loadFromFileJSON(usersPath, &users); err != nil && !errors.Is(err, os.ErrNotExist) {
 		return nil, fmt.Errorf("failed to load users data: %w", err)
 	}
 
 	filmsPath := path.Join(dataPath, filmsFile)
 	films := map[int]FilmInfo{}
-	if err := loadFromFileJSON(filmsPath, &films); err != nil {
+	if err := loadFromFileJSON(filmsPath, &films); err != nil && !errors.Is(err, os.ErrNotExist) {
 		return nil, fmt.Errorf("failed to load films data: %w", err)
 	}
 
 	utilPath := path.Join(dataPath, utilFile)
 	u := util{}
-	if err := loadFromFileJSON(utilPath, &u); err != nil {
+	if err := loadFromFileJSON(utilPath, &u); err != nil && !errors.Is(err, os.ErrNotExist) {
 		return nil, fmt.Errorf("faield to load util data: %w", err)
 	}
-
-	return &Storage{
-		users:     users,
-		films:     films,
-		util:      u,
-		usersPath: usersPath,
-		filmsPath: filmsPath,
-		utilPath:  utilPath,
-	}, nil
-}
-
-func (s *Storage) Register(userID int64, name string, username string) (bool, error) {
-	s.usersMu.RLock()
-	_, ok := s.users[userID]
-	s.usersMu.RUnlock()
-	if ok {
-		return false, nil
+	if u.Monitor != nil {
+		if u.Monitor.MsgId != 0 {
+			u.Monitors = append(u.Monitors, *u.Monitor)
+		}
+		u.Monitor = nil
 	}
 
-	s.usersMu.Lock()
-	defer s.usersMu.Unlock()
-	s.users[userID] = UserInfo{
-		Name:     name,
-		Username: username,
+	s := &Storage{
+		users:      users,
+		films:      films,
+		util:       u,
+		usersPath:  usersPath,
+		filmsPath:  filmsPath,
+		utilPath:   utilPath,
+		eventsPath: path.Join(dataPath, eventsFile),
+		clock:      systemClock{},
 	}
-	if err := s.flushUsers(); err != nil {
-		return false, fmt.Errorf("failed to write users data: %r", err)
+	return s, nil
+}
+
+func (s *Storage) Register(userID int64, p Profile) (bool, error) {
+	added := false
+	err := s.commit(func() (changes, error) {
+		if _, ok := s.users[userID]; ok {
+			return 0, nil
+		}
+		now := s.Now().Unix()
+		s.users[userID] = UserInfo{
+			Name:         p.Name,
+			LastName:     p.LastName,
+			Username:     p.Username,
+			LanguageCode: p.LanguageCode,
+			Joined:       now,
+			LastSeen:     now,
+		}
+		s.record(Event{Type: EventRegister, User: userID, Name: p.Name, Username: p.Username})
+		added = true
+		return changedUsers, nil
+	})
+	if err != nil {
+		return false, err
 	}
 
-	return true, nil
+	return added, nil
 }
 
 func (s *Storage) Status() []FilmStat {
-	if len(s.films) == 0 {
-		return nil
-	}
+	s.filmsMu.RLock()
+	defer s.filmsMu.RUnlock()
+	s.usersMu.RLock()
+	defer s.usersMu.RUnlock()
+	s.utilMu.RLock()
+	defer s.utilMu.RUnlock()
 
-	stats := make([]FilmStat, 0, len(s.films))
-	idx := map[int]int{}
+	return s.status(false)
+}
 
+// StatusFull is Status with proposers and voters
+func (s *Storage) StatusFull() []FilmStat {
 	s.filmsMu.RLock()
-	for filmID, info := range s.films {
-		idx[filmID] = len(stats)
-		stats = append(stats, FilmStat{Id: filmID, Name: info.Name})
-	}
-	s.filmsMu.RUnlock()
-
+	defer s.filmsMu.RUnlock()
 	s.usersMu.RLock()
-	for _, info := range s.users {
-		id, ok := idx[info.Vote]
-		if ok {
-			stats[id].Votes++
-		}
-	}
-	s.usersMu.RUnlock()
-
-	sort.Slice(stats, func(i, j int) bool {
-		return stats[i].Votes > stats[j].Votes
-	})
+	defer s.usersMu.RUnlock()
+	s.utilMu.RLock()
+	defer s.utilMu.RUnlock()
 
-	return stats
+	return s.status(true)
 }
 
-func (s *Storage) StatusFull() []FilmStat {
+// status must be called with films, users and util locked
+func (s *Storage) status(full bool) []FilmStat {
 	if len(s.films) == 0 {
 		return nil
 	}
 
 	stats := make([]FilmStat, 0, len(s.films))
 	idx := map[int]int{}
-
-	s.filmsMu.RLock()
 	for filmID, info := range s.films {
 		idx[filmID] = len(stats)
-		stats = append(stats, FilmStat{
+		stat := FilmStat{
 			Id:      filmID,
 			Name:    info.Name,
-			AddedBy: s.GetUser(info.Added),
-		})
+			Poster:  info.Poster,
+			Trailer: info.Trailer,
+		}
+		if full {
+			stat.AddedBy = s.users[info.Added]
+			stat.AddedById = info.Added
+		}
+		stats = append(stats, stat)
 	}
-	s.filmsMu.RUnlock()
 
-	s.usersMu.RLock()
-	for _, info := range s.users {
+	for userID, info := range s.users {
 		id, ok := idx[info.Vote]
-		if ok {
-			stats[id].Votes++
-			stats[id].Voters = append(stats[id].Voters, info)
+		if !ok {
+			continue
+		}
+		weight, err := s.ballot(userID)
+		if err == nil {
+			stats[id].Votes += weight
+			stats[id].Ballots++
+		}
+		if full {
+			stats[id].Voters = append(stats[id].Voters, Voter{UserInfo: info, Id: userID, Weight: weight, Err: err})
 		}
 	}
-	s.usersMu.RUnlock()
 
-	sort.Slice(stats, func(i, j int) bool {
-		return stats[i].Votes > stats[j].Votes
-	})
+	if full {
+		for i := range stats {
+			slices.SortFunc(stats[i].Voters, func(a, b Voter) int { return cmp.Compare(a.Id, b.Id) })
+		}
+	}
+	s.sortStats(stats)
 
 	return stats
 }
 
 func (s *Storage) AddFilm(userID int64, name string) error {
-	s.filmsMu.Lock()
-	defer s.filmsMu.Unlock()
+	return s.commit(func() (changes, error) {
+		id := s.nextID()
+		s.films[id] = FilmInfo{
+			Name:    name,
+			Added:   userID,
+			AddedAt: s.Now().Unix(),
+		}
+		s.record(Event{Type: EventAdd, User: userID, Film: id, Name: name})
+		return changedFilms | changedUtil, nil
+	})
+}
 
-	s.films[s.newID()] = FilmInfo{
-		Name:  name,
-		Added: userID,
+// AddFilms adds films that are not in the list yet and returns how many were added
+func (s *Storage) AddFilms(userID int64, names []string) (int, error) {
+	added := 0
+	err := s.commit(func() (changes, error) {
+		exists := map[string]struct{}{}
+		for _, info := range s.films {
+			exists[info.Name] = struct{}{}
+		}
+
+		for _, name := range names {
+			if _, ok := exists[name]; ok {
+				continue
+			}
+			exists[name] = struct{}{}
+			id := s.nextID()
+			s.films[id] = FilmInfo{
+				Name:    name,
+				Added:   userID,
+				AddedAt: s.Now().Unix(),
+			}
+			s.record(Event{Type: EventAdd, User: userID, Film: id, Name: name})
+			added++
+		}
+		if added == 0 {
+			return 0, nil
+		}
+		return changedFilms | changedUtil, nil
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	return s.flushFilms()
+	return added, nil
 }
 
-func (s *Storage) RemoveFilm(name string) (bool, error) {
-	s.filmsMu.Lock()
-	defer s.filmsMu.Unlock()
+// RemoveFilm removes films with the given name and clears votes for them.
+// It returns users whose votes were cleared. by is the user who removes, 0 if unknown.
+func (s *Storage) RemoveFilm(by int64, name string) (bool, []int64, error) {
+	var affected []int64
+	found := false
+	err := s.commit(func() (changes, error) {
+		removed := map[int]struct{}{}
+		for id, info := range s.films {
+			if info.Name == name {
+				delete(s.films, id)
+				removed[id] = struct{}{}
+				s.record(Event{Type: EventRemove, User: by, Film: id, Name: name})
+				found = true
+			}
+		}
+		if !found {
+			return 0, nil
+		}
 
-	removed := false
-	for id, info := range s.films {
-		if info.Name == name {
-			delete(s.films, id)
-			if err := s.flushFilms(); err != nil {
-				return false, err
+		s.util.Runoff = slices.DeleteFunc(s.util.Runoff, func(id int) bool {
+			_, ok := removed[id]
+			return ok
+		})
+		for userID, info := range s.users {
+			if _, ok := removed[info.Vote]; ok {
+				info.Vote = 0
+				s.users[userID] = info
+				affected = append(affected, userID)
 			}
-			removed = true
 		}
+		return changedUsers | changedFilms | changedUtil, nil
+	})
+	if err != nil {
+		return false, nil, err
 	}
 
-	return removed, nil
+	return found, affected, nil
+}
+
+func (s *Storage) SetPoster(name string, fileID string) (bool, error) {
+	return s.updateFilm(name, func(_ int, info *FilmInfo) {
+		info.Poster = fileID
+	})
+}
+
+func (s *Storage) SetTrailer(name string, url string) (bool, error) {
+	return s.updateFilm(name, func(_ int, info *FilmInfo) {
+		info.Trailer = url
+	})
 }
 
-func (s *Storage) ResetVotes() {
-	s.usersMu.Lock()
-	defer s.usersMu.Unlock()
+func (s *Storage) RenameFilm(name string, newName string) (bool, error) {
+	return s.updateFilm(name, func(id int, info *FilmInfo) {
+		info.Name = newName
+		s.record(Event{Type: EventRename, Film: id, Name: newName})
+	})
+}
 
-	for id, info := range s.users {
-		info.Vote = 0
-		s.users[id] = info
+// updateFilm applies f to every film with the given name
+func (s *Storage) updateFilm(name string, f func(id int, info *FilmInfo)) (bool, error) {
+	found := false
+	err := s.commit(func() (changes, error) {
+		for id, info := range s.films {
+			if info.Name == name {
+				f(id, &info)
+				s.films[id] = info
+				found = true
+			}
+		}
+		if !found {
+			return 0, nil
+		}
+		return changedFilms, nil
+	})
+	if err != nil {
+		return false, err
 	}
+
+	return found, nil
 }
 
+// ResetVotes closes the voting session, saving its results, and starts a new one.
+// by is the user who resets, 0 if unknown.
+func (s *Storage) ResetVotes(by int64) error {
+	return s.commit(func() (changes, error) {
+		if rec, ok := s.closeSession(); ok {
+			s.util.Sessions = append(s.util.Sessions, rec)
+		}
+		for id, info := range s.users {
+			info.Vote = 0
+			s.users[id] = info
+		}
+		s.record(Event{Type: EventReset, User: by, Before: s.util.Session, After: s.util.Session + 1})
+		s.util.Session++
+		s.util.Seed = rand.Uint64()
+		s.util.Runoff = nil
+		// the passed deadline closed the previous session, not this one
+		if s.deadlinePassed() {
+			s.util.Deadline = 0
+		}
+		return changedUsers | changedUtil, nil
+	})
+}
+
+// Vote sets the user's vote, filmID=0 retracts it. Votes are not accepted after the deadline,
+// a runoff started at it brings a new one.
 func (s *Storage) Vote(userID int64, filmID int) (bool, error) {
-	if filmID == 0 {
-		s.usersMu.Lock()
-		defer s.usersMu.Unlock()
+	err := s.commit(func() (changes, error) {
+		if s.deadlinePassed() {
+			return 0, ErrVotingClosed
+		}
+		if _, ok := s.films[filmID]; !ok && filmID != 0 {
+			return 0, fmt.Errorf("no filmID=%d: %w", filmID, ErrUnknownFilm)
+		}
+		if len(s.util.Runoff) > 0 && filmID != 0 && !slices.Contains(s.util.Runoff, filmID) {
+			return 0, fmt.Errorf("filmID=%d: %w", filmID, ErrNotInRunoff)
+		}
 		usr, ok := s.users[userID]
 		if !ok {
-			return false, fmt.Errorf("no userID=%d", userID)
+			return 0, fmt.Errorf("no userID=%d: %w", userID, ErrUnknownUser)
 		}
+		if usr.Quit {
+			return 0, fmt.Errorf("userID=%d: %w", userID, ErrQuit)
+		}
+		typ := EventVote
+		if filmID == 0 {
+			typ = EventRetract
+		}
+		s.record(Event{Type: typ, User: userID, Film: filmID, Before: usr.Vote, After: filmID})
 		usr.Vote = filmID
 		s.users[userID] = usr
-		return true, nil
+		return changedUsers, nil
+	})
+	if err != nil {
+		return false, err
 	}
 
+	return true, nil
+}
+
+// SetInactive marks a user who blocked the bot (or unblocked it again)
+func (s *Storage) SetInactive(userID int64, inactive bool) error {
+	return s.commit(func() (changes, error) {
+		usr, ok := s.users[userID]
+		if !ok {
+			return 0, fmt.Errorf("no userID=%d: %w", userID, ErrUnknownUser)
+		}
+		if usr.Inactive == inactive {
+			return 0, nil
+		}
+		usr.Inactive = inactive
+		s.users[userID] = usr
+		return changedUsers, nil
+	})
+}
+
+func (s *Storage) Snapshot() Snapshot {
+	s.usersMu.RLock()
+	users := maps.Clone(s.users)
+	s.usersMu.RUnlock()
+
 	s.filmsMu.RLock()
-	_, ok := s.films[filmID]
+	films := maps.Clone(s.films)
 	s.filmsMu.RUnlock()
-	if !ok {
-		return false, fmt.Errorf("no filmID=%d", filmID)
-	}
 
-	s.usersMu.Lock()
-	defer s.usersMu.Unlock()
-	usr, ok := s.users[userID]
-	if !ok {
-		return false, fmt.Errorf("no userID=%d", userID)
+	return Snapshot{
+		Users:   users,
+		Films:   films,
+		Session: s.Session(),
 	}
-	usr.Vote = filmID
-	s.users[userID] = usr
-
-	if err := s.flushUsers(); err != nil {
-		return false, err
-	}
-
-	return true, nil
 }
 
 func (s *Storage) GetUser(userID int64) UserInfo {
@@ -254,24 +508,62 @@ func (s *Storage) GetVote(userID int64) int {
 	return s.users[userID].Vote
 }
 
-func (s *Storage) SetMonitor(chatId int64, msgID int64) {
-	slog.Debug(fmt.Sprintf("set monitor chat=%d msg=%d", chatId, msgID))
-	s.utilMu.Lock()
-	s.util.Monitor = Monitor{ChatId: chatId, MsgId: msgID}
-	if err := s.flushUtil(); err != nil {
-		slog.Error("failed to save util")
+// AddMonitor stores a monitor message. Only the latest monitor is kept for each chat.
+func (s *Storage) AddMonitor(chatID int64, msgID int64) {
+	slog.Debug(fmt.Sprintf("add monitor chat=%d msg=%d", chatID, msgID))
+	if err := s.commit(func() (changes, error) {
+		s.util.Monitors = slices.DeleteFunc(s.util.Monitors, func(m Monitor) bool {
+			return m.ChatId == chatID
+		})
+		s.util.Monitors = append(s.util.Monitors, Monitor{ChatId: chatID, MsgId: msgID})
+		return changedUtil, nil
+	}); err != nil {
+		slog.Error("failed to save util: " + err.Error())
+	}
+}
+
+func (s *Storage) RemoveMonitor(chatID int64, msgID int64) {
+	slog.Debug(fmt.Sprintf("remove monitor chat=%d msg=%d", chatID, msgID))
+	if err := s.commit(func() (changes, error) {
+		s.util.Monitors = slices.DeleteFunc(s.util.Monitors, func(m Monitor) bool {
+			return m.ChatId == chatID && m.MsgId == msgID
+		})
+		return changedUtil, nil
+	}); err != nil {
+		slog.Error("failed to save util: " + err.Error())
 	}
-	s.utilMu.Unlock()
 }
 
-func (s *Storage) GetMonitor() Monitor {
+func (s *Storage) Monitors() []Monitor {
+	s.utilMu.RLock()
+	defer s.utilMu.RUnlock()
+	return slices.Clone(s.util.Monitors)
+}
+
+func (s *Storage) Session() int {
 	s.utilMu.RLock()
 	defer s.utilMu.RUnlock()
-	return s.util.Monitor
+	return s.util.Session
 }
 
-// func (s *Storage) ClearMonitor() {
-// 	s.utilMu.Lock()
-// 	defer s.usersMu.Unlock()
-// 	s.util.Monitor = Monitor{}
-// }
+// SetDeadline sets the voting deadline. Zero time clears it.
+func (s *Storage) SetDeadline(t time.Time) error {
+	return s.commit(func() (changes, error) {
+		if t.IsZero() {
+			s.util.Deadline = 0
+		} else {
+			s.util.Deadline = t.Unix()
+		}
+		return changedUtil, nil
+	})
+}
+
+func (s *Storage) Deadline() time.Time {
+	s.utilMu.RLock()
+	defer s.utilMu.RUnlock()
+
+	if s.util.Deadline == 0 {
+		return time.Time{}
+	}
+	return time.Unix(s.util.Deadline, 0)
+}
@@ -0,0 +1,387 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestStorage opens storage in dir, an empty one is a new deployment
+func newTestStorage(t *testing.T, dir string) *Storage {
+	t.Helper()
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// seed registers two users with a film each, user 1 votes for film 1
+func seed(t *testing.T, s *Storage) {
+	t.Helper()
+	for _, id := range []int64{1, 2} {
+		_, err := s.Register(id, Profile{Name: "user", Username: "user"})
+		must(t, err)
+	}
+	must(t, s.AddFilm(1, "first"))
+	must(t, s.AddFilm(2, "second"))
+	_, err := s.Vote(1, 1)
+	must(t, err)
+}
+
+// TestRestart checks that every mutation is on disk when it returns:
+// storage opened again in the same directory sees the same data.
+func TestRestart(t *testing.T) {
+	tests := []struct {
+		name string
+		op   func(t *testing.T, s *Storage)
+	}{
+		{"register", func(t *testing.T, s *Storage) {
+			added, err := s.Register(3, Profile{Name: "new", LastName: "user", LanguageCode: "en"})
+			must(t, err)
+			if !added {
+				t.Fatal("user is not added")
+			}
+		}},
+		{"add film", func(t *testing.T, s *Storage) {
+			must(t, s.AddFilm(2, "third"))
+		}},
+		{"vote", func(t *testing.T, s *Storage) {
+			_, err := s.Vote(2, 1)
+			must(t, err)
+		}},
+		{"retract", func(t *testing.T, s *Storage) {
+			_, err := s.Vote(1, 0)
+			must(t, err)
+		}},
+		{"reset votes", func(t *testing.T, s *Storage) {
+			must(t, s.ResetVotes(1))
+		}},
+		{"remove film", func(t *testing.T, s *Storage) {
+			found, affected, err := s.RemoveFilm(1, "first")
+			must(t, err)
+			if !found || len(affected) != 1 {
+				t.Fatalf("found=%v affected=%v", found, affected)
+			}
+		}},
+		{"rename film", func(t *testing.T, s *Storage) {
+			found, err := s.RenameFilm("second", "renamed")
+			must(t, err)
+			if !found {
+				t.Fatal("film is not found")
+			}
+		}},
+		{"set inactive", func(t *testing.T, s *Storage) {
+			must(t, s.SetInactive(2, true))
+		}},
+		{"leave", func(t *testing.T, s *Storage) {
+			must(t, s.Leave(1))
+		}},
+		{"start runoff", func(t *testing.T, s *Storage) {
+			s.SetTieBreak(TieRunoff)
+			_, err := s.Vote(2, 2)
+			must(t, err)
+			films, voters, err := s.StartRunoff(time.Now().Add(time.Hour))
+			must(t, err)
+			if len(films) != 2 || len(voters) != 2 {
+				t.Fatalf("films=%v voters=%v", films, voters)
+			}
+		}},
+		{"attended nobody", func(t *testing.T, s *Storage) {
+			id, err := s.AddScreening(Screening{Place: "cinema", Film: "first"})
+			must(t, err)
+			must(t, s.SetAttended(id, nil))
+		}},
+		{"forget the only attendee", func(t *testing.T, s *Storage) {
+			id, err := s.AddScreening(Screening{Place: "cinema", Film: "first"})
+			must(t, err)
+			must(t, s.SetAttended(id, []int64{1}))
+			_, err = s.Forget(1)
+			must(t, err)
+		}},
+		{"forget", func(t *testing.T, s *Storage) {
+			f, err := s.Forget(1)
+			must(t, err)
+			if !f.Vote || f.Films != 1 {
+				t.Fatalf("forgotten %+v", f)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := newTestStorage(t, dir)
+			seed(t, s)
+			tt.op(t, s)
+
+			reopened := newTestStorage(t, dir)
+			if got, want := reopened.Snapshot(), s.Snapshot(); !reflect.DeepEqual(got, want) {
+				t.Errorf("after restart\n got %+v\nwant %+v", got, want)
+			}
+			if got, want := reopened.Runoff(), s.Runoff(); !reflect.DeepEqual(got, want) {
+				t.Errorf("runoff after restart %v, want %v", got, want)
+			}
+			if got, want := reopened.Deadline(), s.Deadline(); !got.Equal(want) {
+				t.Errorf("deadline after restart %v, want %v", got, want)
+			}
+			if got, want := reopened.Screenings(), s.Screenings(); !reflect.DeepEqual(got, want) {
+				t.Errorf("screenings after restart\n got %+v\nwant %+v", got, want)
+			}
+		})
+	}
+}
+
+// newOldStorage is a deployment that has data from before the event log
+func newOldStorage(t *testing.T, dir string, events string) *Storage {
+	t.Helper()
+	must(t, os.WriteFile(filepath.Join(dir, usersFile), []byte(`{"7":{"name":"old","vote":5}}`), 0o644))
+	must(t, os.WriteFile(filepath.Join(dir, filmsFile), []byte(`{"5":{"name":"old film","added_by":7}}`), 0o644))
+	must(t, os.WriteFile(filepath.Join(dir, utilFile), []byte(`{"id_cnt":5}`), 0o644))
+	if events != "" {
+		must(t, os.WriteFile(filepath.Join(dir, eventsFile), []byte(events), 0o644))
+	}
+	return newTestStorage(t, dir)
+}
+
+func replayFile(t *testing.T, dir string) Replayed {
+	t.Helper()
+	f, err := os.Open(filepath.Join(dir, eventsFile))
+	must(t, err)
+	defer f.Close()
+	r, err := Replay(f)
+	must(t, err)
+	return r
+}
+
+func TestRebuild(t *testing.T) {
+	t.Run("complete log", func(t *testing.T) {
+		dir := t.TempDir()
+		s := newOldStorage(t, dir, "")
+		_, err := s.Register(1, Profile{Name: "new"})
+		must(t, err)
+		must(t, s.AddFilm(1, "new film"))
+		_, err = s.Vote(1, 6)
+		must(t, err)
+		_, err = s.Vote(7, 0)
+		must(t, err)
+		want := s.Snapshot()
+
+		must(t, s.Rebuild(false))
+		got := s.Snapshot()
+		if len(got.Users) != len(want.Users) || len(got.Films) != len(want.Films) {
+			t.Fatalf("rebuilt %+v, want %+v", got, want)
+		}
+		for id, info := range want.Users {
+			if got.Users[id].Vote != info.Vote {
+				t.Errorf("user %d votes for %d, want %d", id, got.Users[id].Vote, info.Vote)
+			}
+		}
+		for id, info := range want.Films {
+			if got.Films[id].Name != info.Name {
+				t.Errorf("film %d is %q, want %q", id, got.Films[id].Name, info.Name)
+			}
+		}
+	})
+
+	t.Run("log started over existing data", func(t *testing.T) {
+		dir := t.TempDir()
+		s := newOldStorage(t, dir, `{"time":1,"type":"add","user":7,"film":6,"name":"new film"}`+"\n")
+
+		if err := s.Rebuild(false); !errors.Is(err, ErrIncompleteLog) {
+			t.Fatalf("got %v, want ErrIncompleteLog", err)
+		}
+		must(t, s.Rebuild(true))
+		snap := s.Snapshot()
+		if snap.Users[7].Name != "old" || snap.Users[7].Vote != 5 {
+			t.Errorf("user from before the log is lost: %+v", snap.Users)
+		}
+		if snap.Films[5].Name != "old film" || snap.Films[6].Name != "new film" {
+			t.Errorf("films are not merged: %+v", snap.Films)
+		}
+	})
+
+	t.Run("forget cleans the snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		s := newOldStorage(t, dir, "")
+		if r := replayFile(t, dir); !r.Complete || r.Users[7].Name != "old" {
+			t.Fatalf("the log doesn't start with the state: %+v", r)
+		}
+
+		_, err := s.Forget(7)
+		must(t, err)
+		r := replayFile(t, dir)
+		if _, ok := r.Users[7]; ok {
+			t.Error("forgotten user is in the log")
+		}
+		if r.Films[5].Added != 0 {
+			t.Error("forgotten user still authors a film in the log")
+		}
+	})
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func TestVoteAfterDeadline(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)}
+	s := newTestStorage(t, t.TempDir())
+	s.SetClock(clock)
+	s.SetTieBreak(TieRunoff)
+	seed(t, s)
+	must(t, s.SetDeadline(clock.now.Add(time.Hour)))
+
+	_, err := s.Vote(2, 2)
+	must(t, err)
+	if stats := s.Status(); stats[0].Leader {
+		t.Fatal("the tie is resolved before the deadline")
+	}
+
+	clock.now = clock.now.Add(time.Hour)
+	if _, err := s.Vote(2, 1); !errors.Is(err, ErrVotingClosed) {
+		t.Fatalf("vote after the deadline: got %v, want ErrVotingClosed", err)
+	}
+	if _, err := s.Vote(1, 0); !errors.Is(err, ErrVotingClosed) {
+		t.Fatalf("retract after the deadline: got %v, want ErrVotingClosed", err)
+	}
+
+	// the runoff brings a new deadline
+	films, _, err := s.StartRunoff(clock.now.Add(time.Hour))
+	must(t, err)
+	if len(films) != 2 {
+		t.Fatalf("runoff films %v", films)
+	}
+	_, err = s.Vote(1, 1)
+	must(t, err)
+
+	clock.now = clock.now.Add(time.Hour)
+	if _, err := s.Vote(2, 2); !errors.Is(err, ErrVotingClosed) {
+		t.Fatalf("vote after the runoff: got %v, want ErrVotingClosed", err)
+	}
+	if stats := s.Status(); !stats[0].Leader || stats[0].Id != 1 {
+		t.Fatalf("the runoff winner is not decided: %+v", stats)
+	}
+
+	must(t, s.ResetVotes(0))
+	if !s.Deadline().IsZero() {
+		t.Fatal("reset kept the passed deadline")
+	}
+	_, err = s.Vote(2, 1)
+	must(t, err)
+}
+
+func TestForgetLogFailure(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStorage(t, dir)
+	seed(t, s)
+	want := s.Snapshot()
+
+	// the log can't be read
+	path := filepath.Join(dir, eventsFile)
+	must(t, os.Remove(path))
+	must(t, os.Mkdir(path, 0o755))
+
+	if _, err := s.Forget(1); err == nil {
+		t.Fatal("forget succeeded without rewriting the log")
+	}
+	if got := s.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("failed forget changed the state\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestLeave(t *testing.T) {
+	s := newTestStorage(t, t.TempDir())
+	seed(t, s)
+	must(t, s.Leave(1))
+	if usr := s.GetUser(1); usr.Vote != 0 || !usr.Quit {
+		t.Fatalf("after leave %+v", usr)
+	}
+	if _, err := s.Vote(1, 2); !errors.Is(err, ErrQuit) {
+		t.Fatalf("vote after leave: got %v, want ErrQuit", err)
+	}
+
+	// unblocking the bot is not coming back to the club
+	must(t, s.SetInactive(1, true))
+	must(t, s.SetInactive(1, false))
+	if !s.GetUser(1).Quit {
+		t.Fatal("SetInactive brought the user back")
+	}
+
+	must(t, s.Rejoin(1))
+	_, err := s.Vote(1, 2)
+	must(t, err)
+}
+
+func TestReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{usersFile, filmsFile, utilFile} {
+		must(t, os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0o644))
+	}
+
+	s, err := NewReadOnly(dir)
+	must(t, err)
+	if err := s.AddFilm(1, "film"); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("got %v, want ErrReadOnly", err)
+	}
+	entries, err := os.ReadDir(dir)
+	must(t, err)
+	if len(entries) != 3 {
+		t.Errorf("read-only storage wrote to the directory: %v", entries)
+	}
+	if util, err := os.ReadFile(filepath.Join(dir, utilFile)); err != nil || string(util) != "{}" {
+		t.Errorf("util is changed: %s %v", util, err)
+	}
+}
+
+func TestRepairIsLogged(t *testing.T) {
+	dir := t.TempDir()
+	must(t, os.WriteFile(filepath.Join(dir, usersFile), []byte(`{"1":{"name":"user","vote":9}}`), 0o644))
+	must(t, os.WriteFile(filepath.Join(dir, filmsFile), []byte(`{"5":{"name":"film","added_by":7}}`), 0o644))
+	must(t, os.WriteFile(filepath.Join(dir, utilFile), []byte(`{"id_cnt":5}`), 0o644))
+	s := newTestStorage(t, dir)
+
+	fixed, remaining, err := s.Repair()
+	must(t, err)
+	if len(fixed) != 2 || len(remaining) != 0 {
+		t.Fatalf("fixed %v, remaining %v", fixed, remaining)
+	}
+
+	r := replayFile(t, dir)
+	snap := s.Snapshot()
+	if r.Users[1].Vote != snap.Users[1].Vote || r.Films[5].Added != snap.Films[5].Added {
+		t.Errorf("replayed %+v, stored %+v", r.Snapshot, snap)
+	}
+}
+
+func TestRulesValidate(t *testing.T) {
+	tests := []struct {
+		rules Rules
+		ok    bool
+	}{
+		{Rules{}, true},
+		{Rules{MembersOnly: true, Attended: 2, Of: 3, LoserBonus: 1, LoserStreak: 2}, true},
+		{Rules{LoserStreak: 0}, true},
+		{Rules{LoserBonus: 1, LoserStreak: 0}, false},
+		{Rules{LoserBonus: -1, LoserStreak: 1}, false},
+		{Rules{Attended: 3, Of: 2}, false},
+		{Rules{Attended: -1, Of: 2}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.rules.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%+v: got %v", tt.rules, err)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+var (
+	ErrNotInRunoff  = errors.New("film is not in the runoff")
+	ErrVotingClosed = errors.New("the deadline has passed")
+)
+
+// TieBreak decides which of the films with equal votes wins
+type TieBreak string
+
+const (
+	// ties are kept, films are ordered by id
+	TieNone TieBreak = ""
+	// the film added first wins
+	TieAdded TieBreak = "added"
+	// the film of the member who joined first wins
+	TieSeniority TieBreak = "seniority"
+	// films are shuffled with the session seed, anyone can check the order with it
+	TieRandom TieBreak = "random"
+	// tied films go to a runoff vote after the deadline, a tie after it falls back to TieAdded
+	TieRunoff TieBreak = "runoff"
+)
+
+func ParseTieBreak(s string) (TieBreak, error) {
+	switch p := TieBreak(s); p {
+	case TieNone, TieAdded, TieSeniority, TieRandom, TieRunoff:
+		return p, nil
+	}
+	return TieNone, fmt.Errorf("unknown tie break policy %q", s)
+}
+
+// SetTieBreak sets the policy, it should be called before the storage is used
+func (s *Storage) SetTieBreak(p TieBreak) {
+	s.utilMu.Lock()
+	defer s.utilMu.Unlock()
+	s.tieBreak = p
+}
+
+func (s *Storage) TieBreak() TieBreak {
+	s.utilMu.RLock()
+	defer s.utilMu.RUnlock()
+	return s.tieBreak
+}
+
+// Seed returns the seed of the current session used by TieRandom
+func (s *Storage) Seed() uint64 {
+	s.utilMu.RLock()
+	defer s.utilMu.RUnlock()
+	return s.util.Seed
+}
+
+// Runoff returns films of the running runoff, nil if there is none
+func (s *Storage) Runoff() []int {
+	s.utilMu.RLock()
+	defer s.utilMu.RUnlock()
+	return slices.Clone(s.util.Runoff)
+}
+
+// StartRunoff starts a runoff between the leading films if they are tied, the deadline
+// is replaced by the given one and all votes are cleared. It returns the runoff films
+// and users whose votes were cleared, no films if there is nothing to run off.
+func (s *Storage) StartRunoff(deadline time.Time) ([]int, []int64, error) {
+	var films []int
+	var voters []int64
+	err := s.commit(func() (changes, error) {
+		if s.tieBreak != TieRunoff || len(s.util.Runoff) > 0 {
+			return 0, nil
+		}
+
+		stats := s.status(false)
+		for i := range stats {
+			if stats[i].Votes == 0 || stats[i].Votes != stats[0].Votes {
+				break
+			}
+			films = append(films, stats[i].Id)
+		}
+		if len(films) < 2 {
+			films = nil
+			return 0, nil
+		}
+		slices.Sort(films)
+
+		for id, info := range s.users {
+			if info.Vote != 0 {
+				info.Vote = 0
+				s.users[id] = info
+				voters = append(voters, id)
+			}
+		}
+		s.util.Runoff = films
+		s.util.Deadline = deadline.Unix()
+		s.record(Event{Type: EventRunoff})
+		return changedUsers | changedUtil, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return films, voters, nil
+}
+
+// sortStats orders films by votes and then by the tie break policy.
+// The first film is marked as the leader if the policy has decided the winner.
+// It must be called with films, users and util locked.
+func (s *Storage) sortStats(stats []FilmStat) {
+	keys := make(map[int]uint64, len(stats))
+	for i := range stats {
+		keys[stats[i].Id] = s.tieKey(stats[i].Id)
+	}
+	slices.SortFunc(stats, func(a, b FilmStat) int {
+		return cmp.Or(
+			b.Votes-a.Votes,
+			cmp.Compare(keys[a.Id], keys[b.Id]),
+			a.Id-b.Id,
+		)
+	})
+
+	if len(stats) > 0 && stats[0].Votes > 0 && s.tieResolved() {
+		stats[0].Leader = true
+	}
+}
+
+func (s *Storage) tieKey(id int) uint64 {
+	switch s.tieBreak {
+	case TieSeniority:
+		joined := s.users[s.films[id].Added].Joined
+		if joined == 0 {
+			return math.MaxUint64
+		}
+		return uint64(joined)
+	case TieRandom:
+		return rand.NewPCG(s.util.Seed, uint64(id)).Uint64()
+	default:
+		// ids grow monotonically
+		return uint64(id)
+	}
+}
+
+func (s *Storage) tieResolved() bool {
+	switch s.tieBreak {
+	case TieNone:
+		return false
+	case TieRunoff:
+		return len(s.util.Runoff) > 0 && s.deadlinePassed()
+	default:
+		return true
+	}
+}
@@ -2,13 +2,32 @@ package storage
 
 import (
 	"encoding/json"
-	"log/slog"
+	"fmt"
 	"os"
+	"path/filepath"
 )
 
 type util struct {
-	IdCnt   int     `json:"id_cnt"`
-	Monitor Monitor `json:"monitor"`
+	IdCnt    int       `json:"id_cnt"`
+	Monitors []Monitor `json:"monitors"`
+	Deadline int64     `json:"deadline,omitempty"`
+	Session  int       `json:"session"`
+
+	// seed of the session for TieRandom, published with the results
+	Seed uint64 `json:"seed,omitempty"`
+	// films of the running runoff
+	Runoff []int `json:"runoff,omitempty"`
+
+	Sessions []SessionRecord `json:"sessions,omitempty"`
+
+	ScreeningCnt int         `json:"screening_cnt,omitempty"`
+	Screenings   []Screening `json:"screenings,omitempty"`
+
+	// state of scheduled jobs by name
+	Jobs map[string]int64 `json:"jobs,omitempty"`
+
+	// deprecated: single monitor from older data files, migrated to Monitors on load
+	Monitor *Monitor `json:"monitor,omitempty"`
 }
 
 type Monitor struct {
@@ -26,6 +45,58 @@ func loadFromFileJSON(path string, v any) error {
 	return json.NewDecoder(f).Decode(v)
 }
 
+type changes uint8
+
+const (
+	changedUsers changes = 1 << iota
+	changedFilms
+	changedUtil
+)
+
+// commit is the only way to mutate storage. f runs under write locks of all data
+// and reports what it changed, the changes are written to disk before commit returns.
+func (s *Storage) commit(f func() (changes, error)) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	// lock order: films, users, util
+	s.filmsMu.Lock()
+	defer s.filmsMu.Unlock()
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+	s.utilMu.Lock()
+	defer s.utilMu.Unlock()
+
+	c, err := f()
+	if err != nil {
+		s.pending = s.pending[:0]
+		return err
+	}
+
+	// the log goes first, state files can always be rebuilt from it
+	if err := s.flushEvents(); err != nil {
+		return fmt.Errorf("failed to write events: %w", err)
+	}
+
+	if c&changedUsers != 0 {
+		if err := s.flushUsers(); err != nil {
+			return fmt.Errorf("failed to write users data: %w", err)
+		}
+	}
+	if c&changedFilms != 0 {
+		if err := s.flushFilms(); err != nil {
+			return fmt.Errorf("failed to write films data: %w", err)
+		}
+	}
+	if c&changedUtil != 0 {
+		if err := s.flushUtil(); err != nil {
+			return fmt.Errorf("failed to write util data: %w", err)
+		}
+	}
+
+	return nil
+}
+
 func (s *Storage) flushUsers() error {
 	return saveToFileJSON(s.usersPath, s.users)
 }
@@ -38,29 +109,39 @@ func (s *Storage) flushUtil() error {
 	return saveToFileJSON(s.utilPath, s.util)
 }
 
+// saveToFileJSON replaces the file atomically, so a crash never leaves it half-written
 func saveToFileJSON(path string, v any) error {
 	data, err := json.Marshal(v)
 	if err != nil {
 		return err
 	}
 
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	defer os.Remove(f.Name())
 
-	_, err = f.Write(data)
-	return err
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
 }
 
-func (s *Storage) newID() int {
-	s.utilMu.Lock()
+// nextID must be called inside commit
+func (s *Storage) nextID() int {
 	s.util.IdCnt++
-	id := s.util.IdCnt
-	if err := s.flushUtil(); err != nil {
-		slog.Error("failed to save util")
-	}
-	s.utilMu.Unlock()
-	return id
+	return s.util.IdCnt
 }
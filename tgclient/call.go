@@ -0,0 +1,145 @@
+package tgclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+)
+
+const contentTypeJSON = "application/json"
+
+// Call invokes a Bot API method with req as JSON body and decodes the result into Resp
+func Call[Req any, Resp any](ctx context.Context, c *Client, method string, req Req) (Resp, error) {
+	var zero Resp
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return zero, fmt.Errorf("failed to marshal %s params: %w", method, err)
+	}
+
+	return doCall[Resp](ctx, c, method, contentTypeJSON, data)
+}
+
+// CallMultipart invokes a Bot API method with multipart/form-data body, used to upload files
+func CallMultipart[Resp any](ctx context.Context, c *Client, method string, fields map[string]string, files map[string]InputFile) (Resp, error) {
+	var zero Resp
+
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		if err := w.WriteField(k, v); err != nil {
+			return zero, fmt.Errorf("failed to write %s field %s: %w", method, k, err)
+		}
+	}
+	for k, f := range files {
+		part, err := w.CreateFormFile(k, f.Name)
+		if err != nil {
+			return zero, fmt.Errorf("failed to create %s file %s: %w", method, k, err)
+		}
+		if _, err = part.Write(f.Data); err != nil {
+			return zero, fmt.Errorf("failed to write %s file %s: %w", method, k, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return zero, fmt.Errorf("failed to finish %s body: %w", method, err)
+	}
+
+	return doCall[Resp](ctx, c, method, w.FormDataContentType(), buf.Bytes())
+}
+
+func doCall[Resp any](ctx context.Context, c *Client, method string, contentType string, body []byte) (Resp, error) {
+	var zero Resp
+
+	resp, err := c.doRequest(ctx, method, contentType, body)
+	if err != nil {
+		return zero, fmt.Errorf("failed to call %s: %w", method, err)
+	}
+	defer resp.Close()
+
+	var result Response[Resp]
+	if err = json.NewDecoder(resp).Decode(&result); err != nil {
+		return zero, fmt.Errorf("failed to decode %s response: %w", method, err)
+	}
+	if err = result.Err(method); err != nil {
+		return zero, err
+	}
+
+	return result.Result, nil
+}
+
+// idempotent methods can be repeated after a 5xx response: Telegram may have handled
+// the request before failing, so repeating a send would deliver the message twice
+var idempotent = map[string]bool{
+	methodGetFile:         true,
+	methodGetUpdates:      true,
+	methodSetMyCommands:   true,
+	methodEditMessageText: true,
+	methodGetChatAdmins:   true,
+	methodGetChatMember:   true,
+	methodAnswerCallback:  true,
+}
+
+// doRequest retries on 429 responses, and on 5xx ones for idempotent methods.
+// The last response is returned as is.
+func (c *Client) doRequest(ctx context.Context, method string, contentType string, body []byte) (io.ReadCloser, error) {
+	u := url.URL{
+		Scheme: "https",
+		Host:   tgHost,
+		Path:   path.Join(c.baseURL, method),
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Content-type", contentType)
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to do request: %w", err)
+		}
+
+		retry := resp.StatusCode == http.StatusTooManyRequests ||
+			resp.StatusCode >= http.StatusInternalServerError && idempotent[method]
+		if !retry || attempt >= maxRetries {
+			return resp.Body, nil
+		}
+
+		delay := retryDelay(resp, attempt)
+		resp.Body.Close()
+		if delay > maxRetryAfter {
+			return nil, fmt.Errorf("%s: retry after %s is too long", method, delay)
+		}
+
+		slog.Warn(fmt.Sprintf("%s failed with status %d, retrying in %s", method, resp.StatusCode, delay))
+		if err := SleepCtx(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryDelay uses retry_after from a 429 response, otherwise exponential backoff
+func retryDelay(resp *http.Response, attempt int) time.Duration {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		var result CommonResponse
+		if err := json.NewDecoder(resp.Body).Decode(&result); err == nil &&
+			result.Parameters != nil && result.Parameters.RetryAfter > 0 {
+			return time.Duration(result.Parameters.RetryAfter) * time.Second
+		}
+	}
+	return baseBackoff << attempt
+}
+
+func formatInt(v int64) string {
+	return strconv.FormatInt(v, 10)
+}
@@ -1,15 +1,13 @@
 package tgclient
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log/slog"
 	"net/http"
 	"net/url"
 	"path"
-	"strconv"
 	"time"
 )
 
@@ -17,84 +15,71 @@ const (
 	tgHost = "api.telegram.org"
 
 	methodSendMessage     = "sendMessage"
+	methodSendPhoto       = "sendPhoto"
+	methodSendDocument    = "sendDocument"
+	methodSendMediaGroup  = "sendMediaGroup"
+	methodGetFile         = "getFile"
 	methodGetUpdates      = "getUpdates"
 	methodSetMyCommands   = "setMyCommands"
 	methodEditMessageText = "editMessageText"
 	methodGetChatAdmins   = "getChatAdministrators"
+	methodGetChatMember   = "getChatMember"
+	methodCopyMessage     = "copyMessage"
+	methodAnswerCallback  = "answerCallbackQuery"
 
 	scopeAllPrivate    = "all_private_chats"
 	scopeAllGroupChats = "all_group_chats"
 	scopeAllChatAdmins = "all_chat_administrators"
 	// scopeChat          = "chat"
+
+	// sendMediaGroup accepts 2-10 items
+	MaxMediaGroup = 10
+	// bots can download files up to 20MB
+	MaxDownloadSize = 20 << 20
+
+	maxRetries    = 3
+	maxRetryAfter = time.Minute
+	baseBackoff   = time.Millisecond * 500
 )
 
 type Client struct {
 	baseURL string
 	client  http.Client
+	limiter *limiter
 }
 
 func NewClient(token string) *Client {
 	return &Client{
 		baseURL: "bot" + token,
 		client:  http.Client{Timeout: time.Second * 5},
+		limiter: newLimiter(),
 	}
 }
 
-func (c *Client) Updates(limit int, offset int) ([]Update, error) {
-	q := url.Values{}
-	q.Add("limit", strconv.Itoa(limit))
-	if offset != 0 {
-		q.Add("offset", strconv.Itoa(offset))
-	}
-
-	resp, err := c.doRequest(methodGetUpdates, q, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to do request: %w", err)
-	}
-	defer resp.Close()
-
-	var upd UpdatesResponse
-	if err = json.NewDecoder(resp).Decode(&upd); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
-	}
-	if !upd.Ok {
-		return nil, fmt.Errorf("response is not ok: %d - %s", upd.ErrorCode, upd.Descr)
-	}
-
-	return upd.Updates, nil
+func (c *Client) Updates(ctx context.Context, limit int, offset int) ([]Update, error) {
+	return Call[GetUpdatesParams, []Update](ctx, c, methodGetUpdates, GetUpdatesParams{
+		Offset: offset,
+		Limit:  limit,
+	})
 }
 
-func (c *Client) AnswerWithResult(msg *Message, text string) (*Message, error) {
-	data, err := json.Marshal(SendMessageParams{
+func (c *Client) AnswerWithResult(ctx context.Context, msg *Message, text string, keyboard *InlineKeyboardMarkup) (*Message, error) {
+	return c.sendMessage(ctx, SendMessageParams{
 		ChatId:    msg.Chat.Id,
 		ThreadId:  msg.ThreadId,
 		Text:      text,
 		ParseMode: "HTML",
+		Keyboard:  keyboard,
 	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal message: %w", err)
-	}
-	body := bytes.NewBuffer(data)
-
-	resp, err := c.doRequest(methodSendMessage, nil, body)
-	if err != nil {
-		return nil, fmt.Errorf("faield to send message: %w", err)
-	}
-	defer resp.Close()
+}
 
-	var res WithMessageResponse
-	if err = json.NewDecoder(resp).Decode(&res); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
-	}
-	if !res.Ok {
-		return nil, fmt.Errorf("response is not ok: %d - %s", res.ErrorCode, res.Descr)
+func (c *Client) EditMessage(ctx context.Context, chatID int64, messageID int64, text string, keyboard InlineKeyboardMarkup) error {
+	if err := c.limiter.wait(ctx, chatID); err != nil {
+		return err
 	}
 
-	return &res.Message, nil
-}
-
-func (c *Client) EditMessage(chatID int64, messageID int64, text string, keyboard InlineKeyboardMarkup) error {
-	data, err := json.Marshal(EditMessageParams{
+	// result is either the edited Message or true
+	_, err := Call[EditMessageParams, json.RawMessage](ctx, c, methodEditMessageText, EditMessageParams{
 		SendMessageParams: SendMessageParams{
 			ChatId:    chatID,
 			Text:      text,
@@ -103,171 +88,208 @@ func (c *Client) EditMessage(chatID int64, messageID int64, text string, keyboar
 		},
 		MessageId: messageID,
 	})
-	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
-	}
-	body := bytes.NewBuffer(data)
-
-	resp, err := c.doRequest(methodEditMessageText, nil, body)
-	if err != nil {
-		return fmt.Errorf("faield to send message: %w", err)
-	}
-
-	var result CommonResponse
-	if err = json.NewDecoder(resp).Decode(&result); err != nil {
-		slog.Error("failed to decode EditMessage response: " + err.Error())
-	}
-	if !result.Ok {
-		return fmt.Errorf("failed to edit message with code %d: %s", result.ErrorCode, result.Descr)
-	}
-
-	return nil
+	return err
 }
 
-func (c *Client) SendInlineKeyboard(chatID int64, text string, keyboard InlineKeyboardMarkup) error {
-	data, err := json.Marshal(SendMessageParams{
+func (c *Client) SendInlineKeyboard(ctx context.Context, chatID int64, text string, keyboard InlineKeyboardMarkup) (*Message, error) {
+	return c.sendMessage(ctx, SendMessageParams{
 		ChatId:    chatID,
 		Text:      text,
 		ParseMode: "HTML",
 		Keyboard:  &keyboard,
 	})
-	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
-	}
-	body := bytes.NewBuffer(data)
-
-	resp, err := c.doRequest(methodSendMessage, nil, body)
-	if err != nil {
-		return fmt.Errorf("faield to send message: %w", err)
-	}
-
-	var result CommonResponse
-	if err = json.NewDecoder(resp).Decode(&result); err != nil {
-		slog.Error("failed to decode SendInlineKeyboard response: " + err.Error())
-	}
-	if !result.Ok {
-		return fmt.Errorf("failed to send inline keyboard with code %d: %s", result.ErrorCode, result.Descr)
-	}
-
-	return nil
 }
 
-func (c *Client) Answer(msg *Message, text string) error {
-	data, err := json.Marshal(SendMessageParams{
-		ChatId:    msg.Chat.Id,
-		ThreadId:  msg.ThreadId,
+func (c *Client) SendMessage(ctx context.Context, chatID int64, text string) error {
+	_, err := c.sendMessage(ctx, SendMessageParams{
+		ChatId:    chatID,
 		Text:      text,
 		ParseMode: "HTML",
 	})
-	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
-	}
-	body := bytes.NewBuffer(data)
-
-	resp, err := c.doRequest(methodSendMessage, nil, body)
-	if err != nil {
-		return fmt.Errorf("faield to send message: %w", err)
-	}
+	return err
+}
 
-	var result CommonResponse
-	if err = json.NewDecoder(resp).Decode(&result); err != nil {
-		slog.Error("failed to decode Answer response: " + err.Error())
-	}
-	if !result.Ok {
-		return fmt.Errorf("failed to answer with code %d: %s", result.ErrorCode, result.Descr)
+// CopyMessage sends a copy of any message without a link to the original
+func (c *Client) CopyMessage(ctx context.Context, chatID int64, fromChatID int64, messageID int64) error {
+	if err := c.limiter.wait(ctx, chatID); err != nil {
+		return err
 	}
 
-	return nil
+	_, err := Call[CopyMessageParams, MessageId](ctx, c, methodCopyMessage, CopyMessageParams{
+		ChatId:     chatID,
+		FromChatId: fromChatID,
+		MessageId:  messageID,
+	})
+	return err
 }
 
-func (c *Client) SetCommandsPrivate(commands [][]string) error {
-	return c.setCommands(commands, CommandScope{Type: scopeAllPrivate})
+func (c *Client) Answer(ctx context.Context, msg *Message, text string) error {
+	_, err := c.AnswerWithResult(ctx, msg, text, nil)
+	return err
 }
 
-// func (c *Client) SetCommandChat(commands [][]string, chatId int64) error {
-// 	return c.setCommands(commands, CommandScope{Type: scopeChat, ChatId: chatId})
-// }
+func (c *Client) sendMessage(ctx context.Context, params SendMessageParams) (*Message, error) {
+	if err := c.limiter.wait(ctx, params.ChatId); err != nil {
+		return nil, err
+	}
 
-func (c *Client) SetCommandsGroup(commands [][]string) error {
-	return c.setCommands(commands, CommandScope{Type: scopeAllGroupChats})
+	msg, err := Call[SendMessageParams, Message](ctx, c, methodSendMessage, params)
+	if err != nil {
+		return nil, fmt.Errorf("faield to send message: %w", err)
+	}
+	return &msg, nil
 }
 
-func (c *Client) SetCommandsGroupAdmin(commands [][]string) error {
-	return c.setCommands(commands, CommandScope{Type: scopeAllChatAdmins})
+// SendPhoto sends a photo by file_id or uploads it if photo has no FileId
+func (c *Client) SendPhoto(ctx context.Context, chatID int64, threadID int64, photo InputFile, caption string) (*Message, error) {
+	return c.sendFile(ctx, methodSendPhoto, "photo", chatID, threadID, photo, caption)
 }
 
-func (c *Client) setCommands(commands [][]string, scope CommandScope) error {
-	params := SetCommandsParams{
-		Commands: make([]Command, len(commands)),
-		Scope:    scope,
-	}
-	for i := range commands {
-		params.Commands[i].Cmd = commands[i][0]
-		params.Commands[i].Descr = commands[i][1]
+// SendDocument sends a document by file_id or uploads it if doc has no FileId
+func (c *Client) SendDocument(ctx context.Context, chatID int64, threadID int64, doc InputFile, caption string) (*Message, error) {
+	return c.sendFile(ctx, methodSendDocument, "document", chatID, threadID, doc, caption)
+}
+
+func (c *Client) sendFile(
+	ctx context.Context,
+	method string, field string,
+	chatID int64, threadID int64,
+	file InputFile, caption string,
+) (*Message, error) {
+	if err := c.limiter.wait(ctx, chatID); err != nil {
+		return nil, err
+	}
+
+	var msg Message
+	var err error
+	if file.FileId != "" {
+		params := map[string]any{
+			"chat_id":    chatID,
+			field:        file.FileId,
+			"caption":    caption,
+			"parse_mode": "HTML",
+		}
+		if threadID != 0 {
+			params["message_thread_id"] = threadID
+		}
+		msg, err = Call[map[string]any, Message](ctx, c, method, params)
+	} else {
+		fields := map[string]string{
+			"chat_id":    formatInt(chatID),
+			"caption":    caption,
+			"parse_mode": "HTML",
+		}
+		if threadID != 0 {
+			fields["message_thread_id"] = formatInt(threadID)
+		}
+		msg, err = CallMultipart[Message](ctx, c, method, fields, map[string]InputFile{field: file})
 	}
-	data, err := json.Marshal(params)
 	if err != nil {
-		return fmt.Errorf("failed to marshal commands: %w", err)
+		return nil, fmt.Errorf("failed to send %s: %w", field, err)
 	}
 
-	body := bytes.NewBuffer(data)
-	resp, err := c.doRequest(methodSetMyCommands, nil, body)
-	if err != nil {
-		return fmt.Errorf("failed to set commands: %w", err)
-	}
+	return &msg, nil
+}
 
-	var result CommonResponse
-	if err = json.NewDecoder(resp).Decode(&result); err != nil {
-		return fmt.Errorf("failed to decode SetCommands response: %w", err)
+// SendMediaGroup sends an album of already uploaded files
+func (c *Client) SendMediaGroup(ctx context.Context, chatID int64, threadID int64, media []InputMedia) ([]Message, error) {
+	if err := c.limiter.wait(ctx, chatID); err != nil {
+		return nil, err
 	}
-	if !result.Ok {
-		return fmt.Errorf("failed to set commands with code %d: %s", result.ErrorCode, result.Descr)
+
+	msgs, err := Call[SendMediaGroupParams, []Message](ctx, c, methodSendMediaGroup, SendMediaGroupParams{
+		ChatId:   chatID,
+		ThreadId: threadID,
+		Media:    media,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to send media group: %w", err)
 	}
 
-	return nil
+	return msgs, nil
 }
 
-func (c *Client) ChatAdmins(chatId int64) ([]Admin, error) {
-	q := url.Values{}
-	q.Add("chat_id", fmt.Sprintf("%d", chatId))
-
-	resp, err := c.doRequest(methodGetChatAdmins, q, nil)
+// DownloadFile fetches contents of an uploaded file
+func (c *Client) DownloadFile(ctx context.Context, fileID string) ([]byte, error) {
+	file, err := Call[GetFileParams, File](ctx, c, methodGetFile, GetFileParams{FileId: fileID})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get chat admins: %w", err)
-	}
-
-	var result WithAdminsResponse
-	if err := json.NewDecoder(resp).Decode(&result); err != nil {
-		return nil, fmt.Errorf("faield to decode ChatAdmins response: %w", err)
+		return nil, fmt.Errorf("failed to get file: %w", err)
 	}
-	if !result.Ok {
-		return nil, fmt.Errorf("faield to get chat admins with code %d: %s", result.ErrorCode, result.Descr)
+	if file.FileSize > MaxDownloadSize {
+		return nil, fmt.Errorf("file is too big: %d bytes", file.FileSize)
 	}
 
-	return result.Admins, nil
-}
-
-func (c *Client) doRequest(method string, query url.Values, body io.Reader) (io.ReadCloser, error) {
 	u := url.URL{
 		Scheme: "https",
 		Host:   tgHost,
-		Path:   path.Join(c.baseURL, method),
+		Path:   path.Join("file", c.baseURL, file.FilePath),
 	}
-
-	req, err := http.NewRequest(http.MethodPost, u.String(), body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.Header.Set("Content-type", "application/json")
-	if query != nil {
-		req.URL.RawQuery = query.Encode()
-	}
-
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to do request: %w", err)
+		return nil, fmt.Errorf("failed to download file: %w", err)
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download file: status %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, MaxDownloadSize))
+}
+
+// AnswerCallbackQuery acknowledges a callback query. Text is shown as a toast
+// or, if alert is set, as a dialog. Clients may cache the answer for cacheTime seconds.
+func (c *Client) AnswerCallbackQuery(ctx context.Context, callbackID string, text string, alert bool, cacheTime int) error {
+	_, err := Call[AnswerCallbackParams, bool](ctx, c, methodAnswerCallback, AnswerCallbackParams{
+		CallbackId: callbackID,
+		Text:       text,
+		ShowAlert:  alert,
+		CacheTime:  cacheTime,
+	})
+	return err
+}
+
+func (c *Client) SetCommandsPrivate(ctx context.Context, commands [][]string, lang string) error {
+	return c.setCommands(ctx, commands, CommandScope{Type: scopeAllPrivate}, lang)
+}
+
+// func (c *Client) SetCommandChat(ctx context.Context, commands [][]string, chatId int64) error {
+// 	return c.setCommands(ctx, commands, CommandScope{Type: scopeChat, ChatId: chatId}, "")
+// }
+
+func (c *Client) SetCommandsGroup(ctx context.Context, commands [][]string, lang string) error {
+	return c.setCommands(ctx, commands, CommandScope{Type: scopeAllGroupChats}, lang)
+}
+
+func (c *Client) SetCommandsGroupAdmin(ctx context.Context, commands [][]string, lang string) error {
+	return c.setCommands(ctx, commands, CommandScope{Type: scopeAllChatAdmins}, lang)
+}
+
+// setCommands sets the command list for users with the given language code, empty sets the fallback list
+func (c *Client) setCommands(ctx context.Context, commands [][]string, scope CommandScope, lang string) error {
+	params := SetCommandsParams{
+		Commands:     make([]Command, len(commands)),
+		Scope:        scope,
+		LanguageCode: lang,
+	}
+	for i := range commands {
+		params.Commands[i].Cmd = commands[i][0]
+		params.Commands[i].Descr = commands[i][1]
+	}
+
+	_, err := Call[SetCommandsParams, bool](ctx, c, methodSetMyCommands, params)
+	return err
+}
+
+func (c *Client) ChatAdmins(ctx context.Context, chatId int64) ([]Admin, error) {
+	return Call[ChatParams, []Admin](ctx, c, methodGetChatAdmins, ChatParams{ChatId: chatId})
+}
 
-	return resp.Body, nil
+func (c *Client) ChatMember(ctx context.Context, chatId int64, userId int64) (ChatMember, error) {
+	return Call[ChatMemberParams, ChatMember](ctx, c, methodGetChatMember, ChatMemberParams{ChatId: chatId, UserId: userId})
 }
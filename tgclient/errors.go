@@ -0,0 +1,76 @@
+package tgclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// APIError is a non-ok response from the Bot API
+type APIError struct {
+	Method     string
+	Code       int
+	Descr      string
+	Parameters ResponseParameters
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s failed with code %d: %s", e.Method, e.Code, e.Descr)
+}
+
+// Err returns *APIError for a non-ok response and nil otherwise
+func (r *CommonResponse) Err(method string) error {
+	if r.Ok {
+		return nil
+	}
+	e := &APIError{
+		Method: method,
+		Code:   r.ErrorCode,
+		Descr:  r.Descr,
+	}
+	if r.Parameters != nil {
+		e.Parameters = *r.Parameters
+	}
+	return e
+}
+
+// IsBlocked reports that the user blocked the bot, never started it or deleted the account
+func IsBlocked(err error) bool {
+	return hasDescr(err, http.StatusForbidden,
+		"bot was blocked by the user",
+		"user is deactivated",
+		"bot can't initiate conversation with a user",
+	)
+}
+
+// IsNotModified reports an edit that doesn't change the message
+func IsNotModified(err error) bool {
+	return hasDescr(err, http.StatusBadRequest, "message is not modified")
+}
+
+// IsMessageNotFound reports that the message (or its chat) no longer exists or is not reachable
+func IsMessageNotFound(err error) bool {
+	return hasDescr(err, http.StatusBadRequest,
+		"message to edit not found",
+		"message not found",
+		"chat not found",
+	) || hasDescr(err, http.StatusForbidden,
+		"bot was kicked",
+		"bot is not a member",
+	)
+}
+
+func hasDescr(err error, code int, substrs ...string) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != code {
+		return false
+	}
+	descr := strings.ToLower(apiErr.Descr)
+	for _, s := range substrs {
+		if strings.Contains(descr, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package tgclient
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Telegram limits for bots:
+// ~30 messages per second overall, ~1 message per second in a private chat
+// and 20 messages per minute in a group.
+const (
+	globalRate  = 30.0
+	globalBurst = 30.0
+
+	privateRate  = 1.0
+	privateBurst = 1.0
+
+	groupRate  = 20.0 / 60.0
+	groupBurst = 3.0
+
+	// how often buckets of chats the bot hasn't written to lately are dropped
+	pruneInterval = time.Minute * 10
+)
+
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate, burst float64, now time.Time) *bucket {
+	return &bucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// reserve takes a token and returns how long to wait before it may be used.
+// Tokens may go negative, so concurrent callers queue up behind each other.
+func (b *bucket) reserve(now time.Time) time.Duration {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// full reports whether the bucket has refilled, so it is no different from a new one
+func (b *bucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+type limiter struct {
+	mu     sync.Mutex
+	global *bucket
+	chats  map[int64]*bucket
+	pruned time.Time
+}
+
+func newLimiter() *limiter {
+	now := time.Now()
+	return &limiter{
+		global: newBucket(globalRate, globalBurst, now),
+		chats:  map[int64]*bucket{},
+		pruned: now,
+	}
+}
+
+// wait blocks until a message may be sent to the chat or ctx is done
+func (l *limiter) wait(ctx context.Context, chatID int64) error {
+	return SleepCtx(ctx, l.reserve(chatID))
+}
+
+func (l *limiter) reserve(chatID int64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+	delay := l.global.reserve(now)
+
+	chat, ok := l.chats[chatID]
+	if !ok {
+		// group and channel ids are negative
+		if chatID < 0 {
+			chat = newBucket(groupRate, groupBurst, now)
+		} else {
+			chat = newBucket(privateRate, privateBurst, now)
+		}
+		l.chats[chatID] = chat
+	}
+
+	return max(delay, chat.reserve(now))
+}
+
+// prune drops full buckets from time to time, so the map doesn't grow with every chat.
+// It must be called with mu locked.
+func (l *limiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < pruneInterval {
+		return
+	}
+	l.pruned = now
+	for id, b := range l.chats {
+		if b.full(now) {
+			delete(l.chats, id)
+		}
+	}
+}
+
+// SleepCtx waits for d or until the context is done
+func SleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,29 @@
+package tgclient
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterPrune(t *testing.T) {
+	l := newLimiter()
+	now := time.Now()
+	l.chats[1] = newBucket(privateRate, privateBurst, now)
+	l.chats[1].reserve(now)
+	l.chats[2] = newBucket(groupRate, groupBurst, now)
+	// a backlog that takes longer than the prune interval to send
+	l.chats[3] = newBucket(privateRate, privateBurst, now)
+	for range int(pruneInterval.Seconds()) * 2 {
+		l.chats[3].reserve(now)
+	}
+
+	l.prune(now.Add(time.Second))
+	if len(l.chats) != 3 {
+		t.Fatalf("pruned before the interval: %v", l.chats)
+	}
+
+	l.prune(now.Add(pruneInterval))
+	if _, ok := l.chats[3]; !ok || len(l.chats) != 1 {
+		t.Errorf("after pruning %v, want only the chat with a backlog", l.chats)
+	}
+}
@@ -1,28 +1,52 @@
 package tgclient
 
-type UpdatesResponse struct {
+// Response is a Bot API response with a typed result
+type Response[T any] struct {
 	CommonResponse
-	Updates []Update `json:"result"`
+	Result T `json:"result"`
 }
 
-type WithMessageResponse struct {
-	CommonResponse
-	Message Message `json:"result"`
+type Admin struct {
+	User User `json:"user"`
 }
 
-type WithAdminsResponse struct {
-	CommonResponse
-	Admins []Admin `json:"result"`
+// ChatMember is a chat member of any kind, IsMember is set only for restricted ones
+type ChatMember struct {
+	Status   string `json:"status"`
+	User     User   `json:"user"`
+	IsMember bool   `json:"is_member,omitempty"`
 }
 
-type Admin struct {
-	User User `json:"user"`
+// Member reports whether the user is currently in the chat
+func (m ChatMember) Member() bool {
+	switch m.Status {
+	case MemberCreator, MemberAdministrator, MemberMember:
+		return true
+	case MemberRestricted:
+		return m.IsMember
+	}
+	return false
 }
 
+const (
+	MemberCreator       = "creator"
+	MemberAdministrator = "administrator"
+	MemberMember        = "member"
+	MemberRestricted    = "restricted"
+	MemberLeft          = "left"
+	MemberKicked        = "kicked"
+)
+
 type CommonResponse struct {
-	Ok        bool   `json:"ok"`
-	ErrorCode int    `json:"error_code,omitempty"`
-	Descr     string `json:"description,omitempty"`
+	Ok         bool                `json:"ok"`
+	ErrorCode  int                 `json:"error_code,omitempty"`
+	Descr      string              `json:"description,omitempty"`
+	Parameters *ResponseParameters `json:"parameters,omitempty"`
+}
+
+type ResponseParameters struct {
+	MigrateToChatId int64 `json:"migrate_to_chat_id,omitempty"`
+	RetryAfter      int   `json:"retry_after,omitempty"`
 }
 
 type Update struct {
@@ -40,6 +64,11 @@ type Message struct {
 	Text     string   `json:"text"`
 	Entities []Enitiy `json:"entities"`
 
+	Caption  string      `json:"caption,omitempty"`
+	Photo    []PhotoSize `json:"photo,omitempty"`
+	Document *Document   `json:"document,omitempty"`
+	ReplyTo  *Message    `json:"reply_to_message,omitempty"`
+
 	Keyboard *InlineKeyboardMarkup `json:"reply_markup,omitempty"`
 }
 
@@ -62,17 +91,80 @@ type Enitiy struct {
 }
 
 type User struct {
-	Id       int64  `json:"id"`
-	Name     string `json:"first_name"`
-	Username string `json:"username"`
+	Id           int64  `json:"id"`
+	Name         string `json:"first_name"`
+	LastName     string `json:"last_name,omitempty"`
+	Username     string `json:"username"`
+	LanguageCode string `json:"language_code,omitempty"`
 }
 
 type CallbackQuery struct {
+	Id      string  `json:"id"`
 	From    User    `json:"from"`
 	Data    string  `json:"data"`
 	Message Message `json:"message"`
 }
 
+type PhotoSize struct {
+	FileId   string `json:"file_id"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	FileSize int    `json:"file_size,omitempty"`
+}
+
+type Document struct {
+	FileId   string `json:"file_id"`
+	FileName string `json:"file_name,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+}
+
+type File struct {
+	FileId   string `json:"file_id"`
+	FileSize int    `json:"file_size,omitempty"`
+	FilePath string `json:"file_path,omitempty"`
+}
+
+type GetFileParams struct {
+	FileId string `json:"file_id"`
+}
+
+// InputFile is either an already uploaded file (FileId) or new file contents
+type InputFile struct {
+	FileId string
+	Name   string
+	Data   []byte
+}
+
+const MediaTypePhoto = "photo"
+
+// InputMedia is an item of a media group, Media is a file_id or URL
+type InputMedia struct {
+	Type      string `json:"type"`
+	Media     string `json:"media"`
+	Caption   string `json:"caption,omitempty"`
+	ParseMode string `json:"parse_mode,omitempty"`
+}
+
+type SendMediaGroupParams struct {
+	ChatId   int64        `json:"chat_id"`
+	ThreadId int64        `json:"message_thread_id,omitempty"`
+	Media    []InputMedia `json:"media"`
+}
+
+type GetUpdatesParams struct {
+	Offset int `json:"offset,omitempty"`
+	Limit  int `json:"limit,omitempty"`
+}
+
+type ChatParams struct {
+	ChatId int64 `json:"chat_id"`
+}
+
+type ChatMemberParams struct {
+	ChatId int64 `json:"chat_id"`
+	UserId int64 `json:"user_id"`
+}
+
 type SendMessageParams struct {
 	ChatId    int64  `json:"chat_id"`
 	ThreadId  int64  `json:"message_thread_id,omitempty"`
@@ -82,14 +174,32 @@ type SendMessageParams struct {
 	Keyboard *InlineKeyboardMarkup `json:"reply_markup,omitempty"`
 }
 
+type CopyMessageParams struct {
+	ChatId     int64 `json:"chat_id"`
+	FromChatId int64 `json:"from_chat_id"`
+	MessageId  int64 `json:"message_id"`
+}
+
+type MessageId struct {
+	Id int64 `json:"message_id"`
+}
+
 type EditMessageParams struct {
 	SendMessageParams
 	MessageId int64 `json:"message_id"`
 }
 
+type AnswerCallbackParams struct {
+	CallbackId string `json:"callback_query_id"`
+	Text       string `json:"text,omitempty"`
+	ShowAlert  bool   `json:"show_alert,omitempty"`
+	CacheTime  int    `json:"cache_time,omitempty"`
+}
+
 type SetCommandsParams struct {
-	Commands []Command    `json:"commands"`
-	Scope    CommandScope `json:"scope"`
+	Commands     []Command    `json:"commands"`
+	Scope        CommandScope `json:"scope"`
+	LanguageCode string       `json:"language_code,omitempty"`
 }
 
 type Command struct {